@@ -1,19 +1,97 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
+	"go/scanner"
+	"io/ioutil"
+	"os"
 
 	"github.com/shogo82148/go2xs"
 )
 
+// config is the configuration file of go2xs, written in JSON.
+// The command line flags override it.
+type config struct {
+	Name string `json:"name"`
+	go2xs.Metadata
+}
+
 func main() {
-	var name string
-	flag.StringVar(&name, "name", "", "library name")
+	var configFile string
+	var flags config
+	var dir string
+	var emptyNilSlice bool
+	var strict bool
+	var panicStackTrace bool
+	var naming string
+	flag.StringVar(&configFile, "config", "", "configuration file in JSON")
+	flag.StringVar(&flags.Name, "name", "", "library name")
+	flag.StringVar(&flags.Version, "module-version", "", "version of the module (default \"0.01\")")
+	flag.StringVar(&flags.Abstract, "abstract", "", "one line description of the module")
+	flag.StringVar(&flags.Author, "author", "", "author of the module, e.g. \"John Doe <john@example.com>\"")
+	flag.StringVar(&flags.License, "license", "", "license of the module, e.g. perl_5, mit (default \"unknown\")")
+	flag.StringVar(&flags.MinPerlVersion, "min-perl-version", "", "minimum version of Perl (default \"5.010000\")")
+	flag.StringVar(&dir, "dir", ".", "directory to write the generated files")
+	flag.BoolVar(&emptyNilSlice, "empty-nil-slice", false, "convert nil slices into empty array references instead of undef")
+	flag.BoolVar(&strict, "strict", false, "croak for numbers out of range and non-numeric strings")
+	flag.BoolVar(&panicStackTrace, "panic-stack-trace", false, "append the stack trace of Go to the messages of panics")
+	flag.StringVar(&naming, "naming", string(go2xs.NamingAsIs), "naming convention of the functions that the directives don't name, as-is or snake_case")
 	flag.Parse()
+
+	var cfg config
+	if configFile != "" {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			fatal(err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			fatal(err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			cfg.Name = flags.Name
+		case "module-version":
+			cfg.Version = flags.Version
+		case "abstract":
+			cfg.Abstract = flags.Abstract
+		case "author":
+			cfg.Author = flags.Author
+		case "license":
+			cfg.License = flags.License
+		case "min-perl-version":
+			cfg.MinPerlVersion = flags.MinPerlVersion
+		}
+	})
+	if cfg.Name == "" {
+		fatal(errors.New("go2xs: the library name is required"))
+	}
+
 	gen := go2xs.NewGenerator()
+	gen.EmptyNilSlice = emptyNilSlice
+	gen.Strict = strict
+	gen.PanicStackTrace = panicStackTrace
+	gen.Metadata = cfg.Metadata
+	gen.Naming = go2xs.Naming(naming)
 	for _, f := range flag.Args() {
-		gen.ParseFile(f)
+		if err := gen.ParseFile(f); err != nil {
+			fatal(err)
+		}
+	}
+	if err := gen.Generate(); err != nil {
+		fatal(err)
+	}
+	if err := gen.OutputTo(go2xs.DirSink(dir), cfg.Name); err != nil {
+		fatal(err)
 	}
-	gen.Generate()
-	gen.Output(name)
+}
+
+// fatal prints the error, and exits with non-zero status.
+// All errors in scanner.ErrorList are printed with their positions.
+func fatal(err error) {
+	scanner.PrintError(os.Stderr, err)
+	os.Exit(1)
 }
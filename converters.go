@@ -0,0 +1,840 @@
+package go2xs
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// converters generates Go functions that convert values between SV and Go.
+// The functions are shared by all FuncGenerators.
+type converters struct {
+	// emptyNilSlice converts nil slices into empty array references instead of undef.
+	emptyNilSlice bool
+
+	names []string
+	funcs map[string]string
+
+	// pkg is the package of the Go functions. The converters are generated into it.
+	pkg *types.Package
+
+	// imports are the names of the packages that the converters refer, keyed by the import path.
+	imports map[string]string
+
+	// types that are exposed as Perl classes.
+	// pointers to them are converted into blessed objects.
+	classes map[string]bool
+}
+
+func newConverters() *converters {
+	return &converters{
+		funcs:   map[string]string{},
+		imports: map[string]string{},
+		classes: map[string]bool{},
+	}
+}
+
+// code returns the Go code of converter functions.
+func (c *converters) code() string {
+	buf := &bytes.Buffer{}
+	for _, name := range c.names {
+		fmt.Fprintln(buf, c.funcs[name])
+	}
+	return buf.String()
+}
+
+// importDecls returns the import declarations of the packages that the converters refer.
+func (c *converters) importDecls() string {
+	paths := make([]string, 0, len(c.imports))
+	for p := range c.imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	buf := &bytes.Buffer{}
+	for _, p := range paths {
+		if name := c.imports[p]; name != path.Base(p) {
+			fmt.Fprintf(buf, "import %s %q\n", name, p)
+		} else {
+			fmt.Fprintf(buf, "import %q\n", p)
+		}
+	}
+	return buf.String()
+}
+
+// qualifier returns the name of the package for types.TypeString, and imports the package.
+func (c *converters) qualifier(pkg *types.Package) string {
+	if pkg == c.pkg || (c.pkg != nil && pkg.Path() == c.pkg.Path()) {
+		return ""
+	}
+	if name, ok := c.imports[pkg.Path()]; ok {
+		return name
+	}
+
+	// rename the packages that have same names
+	used := map[string]bool{"C": true, "unsafe": true}
+	for _, name := range c.imports {
+		used[name] = true
+	}
+	name := pkg.Name()
+	for i := 2; used[name]; i++ {
+		name = pkg.Name() + strconv.Itoa(i)
+	}
+	c.imports[pkg.Path()] = name
+	return name
+}
+
+// typeString returns the Go code of the type.
+func (c *converters) typeString(t types.Type) string {
+	return types.TypeString(t, c.qualifier)
+}
+
+// isClass reports whether the type is a pointer to the type exposed as the Perl class.
+func (c *converters) isClass(t types.Type) (*types.Named, bool) {
+	ptr, ok := types.Unalias(t).(*types.Pointer)
+	if !ok {
+		return nil, false
+	}
+	named, ok := types.Unalias(ptr.Elem()).(*types.Named)
+	if !ok || named.Obj().Pkg() != c.pkg {
+		return nil, false
+	}
+	return named, c.classes[named.Obj().Name()]
+}
+
+// add registers the converter function. It returns false if the function is already registered.
+func (c *converters) add(name string) bool {
+	if _, ok := c.funcs[name]; ok {
+		return false
+	}
+	c.names = append(c.names, name)
+	c.funcs[name] = ""
+	return true
+}
+
+var primitiveFromSV = map[string]string{
+	"int8":    "int8(go2xsSVIV(sv))",
+	"uint8":   "uint8(go2xsSVUV(sv))",
+	"int16":   "int16(go2xsSVIV(sv))",
+	"uint16":  "uint16(go2xsSVUV(sv))",
+	"int32":   "int32(go2xsSVIV(sv))",
+	"uint32":  "uint32(go2xsSVUV(sv))",
+	"int64":   "int64(go2xsSVIV(sv))",
+	"uint64":  "uint64(go2xsSVUV(sv))",
+	"int":     "int(go2xsSVIV(sv))",
+	"uint":    "uint(go2xsSVUV(sv))",
+	"float32": "float32(go2xsSVNV(sv))",
+	"float64": "float64(go2xsSVNV(sv))",
+	"bool":    "go2xsSVTrue(sv)",
+	"string":  "go2xsSVString(sv)",
+}
+
+// strictRanges are the functions and the ranges for checking numbers in strict mode
+var strictRanges = map[string]struct {
+	conv string
+	args string
+}{
+	"int8":    {"go2xsSVIVStrict", "-1 << 7, 1<<7 - 1"},
+	"uint8":   {"go2xsSVUVStrict", "1<<8 - 1"},
+	"int16":   {"go2xsSVIVStrict", "-1 << 15, 1<<15 - 1"},
+	"uint16":  {"go2xsSVUVStrict", "1<<16 - 1"},
+	"int32":   {"go2xsSVIVStrict", "-1 << 31, 1<<31 - 1"},
+	"uint32":  {"go2xsSVUVStrict", "1<<32 - 1"},
+	"int64":   {"go2xsSVIVStrict", "-1 << 63, 1<<63 - 1"},
+	"uint64":  {"go2xsSVUVStrict", "1<<64 - 1"},
+	"int":     {"go2xsSVIVStrict", "go2xsMinInt, go2xsMaxInt"},
+	"uint":    {"go2xsSVUVStrict", "go2xsMaxUint"},
+	"float32": {"go2xsSVNVStrict", "go2xsMaxFloat32"},
+	"float64": {"go2xsSVNVStrict", "go2xsMaxFloat64"},
+}
+
+var primitiveToSV = map[string]string{
+	"int8":    "go2xsNewSVIV(int64(v))",
+	"uint8":   "go2xsNewSVUV(uint64(v))",
+	"int16":   "go2xsNewSVIV(int64(v))",
+	"uint16":  "go2xsNewSVUV(uint64(v))",
+	"int32":   "go2xsNewSVIV(int64(v))",
+	"uint32":  "go2xsNewSVUV(uint64(v))",
+	"int64":   "go2xsNewSVIV(int64(v))",
+	"uint64":  "go2xsNewSVUV(uint64(v))",
+	"int":     "go2xsNewSVIV(int64(v))",
+	"uint":    "go2xsNewSVUV(uint64(v))",
+	"float32": "go2xsNewSVNV(float64(v))",
+	"float64": "go2xsNewSVNV(float64(v))",
+	"bool":    "go2xsNewSVBool(v)",
+	"string":  "go2xsNewSVString(v)",
+}
+
+// basicName returns the name of the basic type. Aliases such as byte and rune are resolved.
+func basicName(t *types.Basic) string {
+	return types.Typ[t.Kind()].Name()
+}
+
+// typeName mangles the type into a part of Go identifier.
+func (c *converters) typeName(t types.Type) (string, bool) {
+	switch t := types.Unalias(t).(type) {
+	case *types.Basic:
+		name := basicName(t)
+		if _, ok := primitiveFromSV[name]; !ok {
+			return "", false
+		}
+		return name, true
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() == nil || t.TypeArgs().Len() > 0 {
+			// built-in error interface and generic types
+			return "", false
+		}
+		if q := c.qualifier(obj.Pkg()); q != "" {
+			return q + "_" + obj.Name(), true
+		}
+		return obj.Name(), true
+	case *types.Slice:
+		if isByteSlice(t) {
+			return "bytes", true
+		}
+		elem, ok := c.typeName(t.Elem())
+		if !ok {
+			return "", false
+		}
+		return "slice_" + elem, true
+	case *types.Map:
+		if !isString(t.Key()) {
+			return "", false
+		}
+		key, ok := c.typeName(t.Key())
+		if !ok {
+			return "", false
+		}
+		elem, ok := c.typeName(t.Elem())
+		if !ok {
+			return "", false
+		}
+		return "map_" + key + "_" + elem, true
+	case *types.Pointer:
+		elem, ok := c.typeName(t.Elem())
+		if !ok {
+			return "", false
+		}
+		return "ptr_" + elem, true
+	case *types.Signature:
+		if t.Variadic() {
+			return "", false
+		}
+		var params, results []string
+		for _, v := range tupleVars(t.Params(), "") {
+			name, ok := c.typeName(v.typ)
+			if !ok {
+				return "", false
+			}
+			params = append(params, name)
+		}
+		for _, v := range tupleVars(t.Results(), "") {
+			if isErrorType(v.typ) {
+				results = append(results, "error")
+				continue
+			}
+			name, ok := c.typeName(v.typ)
+			if !ok {
+				return "", false
+			}
+			results = append(results, name)
+		}
+		return "func_" + strings.Join(params, "_") + "_to_" + strings.Join(results, "_"), true
+	}
+	return "", false
+}
+
+// fromSV returns the name of the function that converts SV into the type.
+// The function has the signature func(sv unsafe.Pointer) (T, error).
+// In strict mode, the function returns errors for numbers out of range and non-numeric strings.
+func (c *converters) fromSV(t types.Type, strict bool) (string, bool) {
+	name, ok := c.typeName(t)
+	if !ok {
+		return "", false
+	}
+	fname := "go2xsFromSV_" + name
+	if strict {
+		fname = "go2xsFromSVStrict_" + name
+	}
+	if !c.add(fname) {
+		return fname, true
+	}
+
+	var code string
+	typ := c.typeString(t)
+	switch t := types.Unalias(t).(type) {
+	case *types.Named:
+		if st, ok := t.Underlying().(*types.Struct); ok {
+			if code, ok = c.structFromSV(fname, typ, st, strict); !ok {
+				return c.fail(fname)
+			}
+			break
+		}
+		// convert via the underlying type
+		conv, ok := c.fromSV(t.Underlying(), strict)
+		if !ok {
+			return c.fail(fname)
+		}
+		code = fmt.Sprintf(`func %s(sv unsafe.Pointer) (%s, error) {
+	v, err := %s(sv)
+	return %s(v), err
+}
+`, fname, typ, conv, typ)
+	case *types.Basic:
+		name := basicName(t)
+		if r, ok := strictRanges[name]; ok && strict {
+			args := strconv.Quote(name)
+			if r.args != "" {
+				args += ", " + r.args
+			}
+			code = fmt.Sprintf(`func %s(sv unsafe.Pointer) (%s, error) {
+	v, err := %s(sv, %s)
+	return %s(v), err
+}
+`, fname, name, r.conv, args, name)
+			break
+		}
+		code = fmt.Sprintf(`func %s(sv unsafe.Pointer) (%s, error) {
+	return %s, nil
+}
+`, fname, name, primitiveFromSV[name])
+	case *types.Slice:
+		if isByteSlice(t) {
+			code = fmt.Sprintf(`func %s(sv unsafe.Pointer) ([]byte, error) {
+	return go2xsSVBytes(sv), nil
+}
+`, fname)
+			break
+		}
+		elem, ok := c.fromSV(t.Elem(), strict)
+		if !ok {
+			return c.fail(fname)
+		}
+		code = fmt.Sprintf(`func %s(sv unsafe.Pointer) (%s, error) {
+	if !go2xsSVOK(sv) {
+		return nil, nil
+	}
+	if !go2xsIsArrayRef(sv) {
+		return nil, go2xsTypeError("an ARRAY reference")
+	}
+	v := make(%s, go2xsAVLen(sv))
+	for i := range v {
+		e, err := %s(go2xsAVFetch(sv, i))
+		if err != nil {
+			return nil, go2xsWrapPath(err, go2xsIndexPath(i))
+		}
+		v[i] = e
+	}
+	return v, nil
+}
+`, fname, typ, typ, elem)
+	case *types.Map:
+		elem, ok := c.fromSV(t.Elem(), strict)
+		if !ok {
+			return c.fail(fname)
+		}
+		key := "key"
+		if _, ok := types.Unalias(t.Key()).(*types.Basic); !ok {
+			key = c.typeString(t.Key()) + "(key)"
+		}
+		code = fmt.Sprintf(`func %s(sv unsafe.Pointer) (%s, error) {
+	if !go2xsSVOK(sv) {
+		return nil, nil
+	}
+	if !go2xsIsHashRef(sv) {
+		return nil, go2xsTypeError("a HASH reference")
+	}
+	v := make(%s, go2xsHVIterInit(sv))
+	for {
+		key, val, ok := go2xsHVIterNext(sv)
+		if !ok {
+			break
+		}
+		e, err := %s(val)
+		if err != nil {
+			return nil, go2xsWrapPath(err, go2xsKeyPath(key))
+		}
+		v[%s] = e
+	}
+	return v, nil
+}
+`, fname, typ, typ, elem, key)
+	case *types.Pointer:
+		if named, ok := c.isClass(t); ok {
+			class := named.Obj().Name()
+			code = fmt.Sprintf(`func %s(sv unsafe.Pointer) (%s, error) {
+	if !go2xsSVOK(sv) {
+		return nil, nil
+	}
+	v, ok := go2xsObjectValue(sv, go2xsModule+%q).(%s)
+	if !ok {
+		return nil, go2xsTypeError("a " + go2xsModule + %q)
+	}
+	return v, nil
+}
+`, fname, typ, "::"+class, typ, "::"+class+" object")
+			break
+		}
+		elem, ok := c.fromSV(t.Elem(), strict)
+		if !ok {
+			return c.fail(fname)
+		}
+		code = fmt.Sprintf(`func %s(sv unsafe.Pointer) (%s, error) {
+	if !go2xsSVOK(sv) {
+		return nil, nil
+	}
+	v, err := %s(sv)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+`, fname, typ, elem)
+	case *types.Signature:
+		if code, ok = c.callbackFromSV(fname, t, strict); !ok {
+			return c.fail(fname)
+		}
+	}
+	c.funcs[fname] = code
+	return fname, true
+}
+
+// toSV returns the name of the function that converts the type into a new SV.
+// The function has the signature func(v T) unsafe.Pointer.
+func (c *converters) toSV(t types.Type) (string, bool) {
+	name, ok := c.typeName(t)
+	if !ok {
+		return "", false
+	}
+	fname := "go2xsToSV_" + name
+	if !c.add(fname) {
+		return fname, true
+	}
+
+	var code string
+	typ := c.typeString(t)
+	switch t := types.Unalias(t).(type) {
+	case *types.Named:
+		if st, ok := t.Underlying().(*types.Struct); ok {
+			if code, ok = c.structToSV(fname, typ, st); !ok {
+				return c.fail(fname)
+			}
+			break
+		}
+		// convert via the underlying type
+		conv, ok := c.toSV(t.Underlying())
+		if !ok {
+			return c.fail(fname)
+		}
+		code = fmt.Sprintf(`func %s(v %s) unsafe.Pointer {
+	return %s(%s(v))
+}
+`, fname, typ, conv, c.typeString(t.Underlying()))
+	case *types.Basic:
+		name := basicName(t)
+		code = fmt.Sprintf(`func %s(v %s) unsafe.Pointer {
+	return %s
+}
+`, fname, name, primitiveToSV[name])
+	case *types.Slice:
+		if isByteSlice(t) {
+			code = fmt.Sprintf(`func %s(v []byte) unsafe.Pointer {
+	return go2xsNewSVBytes(v)
+}
+`, fname)
+			break
+		}
+		elem, ok := c.toSV(t.Elem())
+		if !ok {
+			return c.fail(fname)
+		}
+		nilValue := "go2xsNewUndef()"
+		if c.emptyNilSlice {
+			nilValue = "go2xsNewAVRef(0)"
+		}
+		code = fmt.Sprintf(`func %s(v %s) unsafe.Pointer {
+	if v == nil {
+		return %s
+	}
+	rv := go2xsNewAVRef(len(v))
+	for _, e := range v {
+		go2xsAVPush(rv, %s(e))
+	}
+	return rv
+}
+`, fname, typ, nilValue, elem)
+	case *types.Map:
+		elem, ok := c.toSV(t.Elem())
+		if !ok {
+			return c.fail(fname)
+		}
+		key := "key"
+		if _, ok := types.Unalias(t.Key()).(*types.Basic); !ok {
+			key = "string(key)"
+		}
+		code = fmt.Sprintf(`func %s(v %s) unsafe.Pointer {
+	if v == nil {
+		return go2xsNewUndef()
+	}
+	rv := go2xsNewHVRef()
+	for key, e := range v {
+		go2xsHVStore(rv, %s, %s(e))
+	}
+	return rv
+}
+`, fname, typ, key, elem)
+	case *types.Pointer:
+		if named, ok := c.isClass(t); ok {
+			code = fmt.Sprintf(`func %s(v %s) unsafe.Pointer {
+	if v == nil {
+		return go2xsNewUndef()
+	}
+	return go2xsNewObject(go2xsModule+%q, v)
+}
+`, fname, typ, "::"+named.Obj().Name())
+			break
+		}
+		elem, ok := c.toSV(t.Elem())
+		if !ok {
+			return c.fail(fname)
+		}
+		code = fmt.Sprintf(`func %s(v %s) unsafe.Pointer {
+	if v == nil {
+		return go2xsNewUndef()
+	}
+	return %s(*v)
+}
+`, fname, typ, elem)
+	default:
+		return c.fail(fname)
+	}
+	c.funcs[fname] = code
+	return fname, true
+}
+
+// callbackFromSV generates the function that converts code references into Go functions.
+// The Go function calls the Perl code with the arguments converted into SVs.
+// If the Perl code dies, the Go function returns the error if its last result is error, otherwise panics.
+// Calls from other goroutines are dispatched to the Perl thread.
+func (c *converters) callbackFromSV(fname string, t *types.Signature, strict bool) (string, bool) {
+	// the names in the signature may conflict with local variables, so they are not used.
+	params := tupleVars(t.Params(), "a")
+	results := tupleVars(t.Results(), "r")
+	for i := range params {
+		params[i].name = fmt.Sprintf("a%d", i)
+	}
+	for i := range results {
+		results[i].name = fmt.Sprintf("r%d", i)
+	}
+	hasError := len(results) > 0 && isErrorType(results[len(results)-1].typ)
+	values := results
+	if hasError {
+		values = results[:len(results)-1]
+	}
+
+	var paramDecls, args, resultDecls []string
+	for _, p := range params {
+		conv, ok := c.toSV(p.typ)
+		if !ok {
+			return "", false
+		}
+		paramDecls = append(paramDecls, p.name+" "+c.typeString(p.typ))
+		args = append(args, conv+"("+p.name+")")
+	}
+	for _, r := range results {
+		resultDecls = append(resultDecls, r.name+" "+c.typeString(r.typ))
+	}
+
+	// how to return the error
+	fail := "panic(err)"
+	if hasError {
+		fail = fmt.Sprintf("%s = err\n\t\t\t\treturn", results[len(results)-1].name)
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `func %s(sv unsafe.Pointer) (%s, error) {
+	if !go2xsSVOK(sv) {
+		return nil, nil
+	}
+	if !go2xsIsCodeRef(sv) {
+		return nil, go2xsTypeError("a CODE reference")
+	}
+	cb := go2xsNewCallback(sv)
+	return func(%s) (%s) {
+		go2xsRunOnPerlThread(func() {
+			ret, err := cb.Call([]unsafe.Pointer{%s}, %d)
+			if err != nil {
+				%s
+			}
+			defer go2xsFreeSVs(ret)
+`, fname, c.typeString(t), strings.Join(paramDecls, ", "), strings.Join(resultDecls, ", "), strings.Join(args, ", "), len(values), fail)
+	for i, r := range values {
+		conv, ok := c.fromSV(r.typ, strict)
+		if !ok {
+			return "", false
+		}
+		fmt.Fprintf(buf, `			%s, err = %s(ret[%d])
+			if err != nil {
+				err = go2xsWrapPath(err, "result%d")
+				%s
+			}
+`, r.name, conv, i, i, fail)
+	}
+	fmt.Fprint(buf, "\t\t})\n\t\treturn\n\t}, nil\n}\n")
+	return buf.String(), true
+}
+
+// structField is an exported field of structs.
+type structField struct {
+	// name of the Go field. promoted fields are selected through the embedded fields, e.g. "Base.ID".
+	name string
+
+	// key of the Perl hash
+	key string
+
+	omitEmpty bool
+	typ       types.Type
+
+	// depth is the number of embedded fields that the field is promoted through.
+	depth int
+
+	// tagged is true if the key is given by the tag.
+	tagged bool
+
+	// ptrs are the embedded pointers that the field is promoted through.
+	// the field is available only if they are not nil.
+	ptrs []embeddedPtr
+}
+
+// embeddedPtr is the embedded field of a pointer to struct.
+type embeddedPtr struct {
+	// name of the Go field, e.g. "Base" or "Outer.Base".
+	name string
+
+	// elem is the type of the struct that the pointer points to.
+	elem types.Type
+}
+
+// structFields returns the exported fields of the struct.
+// The key names are taken from go2xs tags, or json tags if go2xs tags are missing.
+// The fields of embedded structs are promoted by the same rules as encoding/json:
+// the shallowest field wins, and the tagged one wins among the fields of the same depth.
+// Fields of the same key that cannot be decided are ignored.
+// pkg is the package of the generated code, which can access unexported embedded fields of it.
+func structFields(pkg *types.Package, st *types.Struct) []structField {
+	type embedded struct {
+		st   *types.Struct
+		name string
+		ptrs []embeddedPtr
+	}
+
+	var fields []structField
+	visited := map[*types.Struct]bool{}
+	next := []embedded{{st: st}}
+	for depth := 0; len(next) > 0; depth++ {
+		current := next
+		next = nil
+		for _, e := range current {
+			if visited[e.st] {
+				continue
+			}
+			visited[e.st] = true
+
+			for i := 0; i < e.st.NumFields(); i++ {
+				field := e.st.Field(i)
+				name := field.Name()
+				if e.name != "" {
+					name = e.name + "." + name
+				}
+
+				// the type of the struct, if the field is embedded struct or pointer to struct
+				var embeddedStruct *types.Struct
+				var isPtr bool
+				if field.Embedded() {
+					t := types.Unalias(field.Type())
+					if ptr, ok := t.(*types.Pointer); ok {
+						t = types.Unalias(ptr.Elem())
+						isPtr = true
+					}
+					embeddedStruct, _ = t.Underlying().(*types.Struct)
+					if !field.Exported() && (embeddedStruct == nil || field.Pkg() != pkg) {
+						// unexported non-struct types are ignored, and embedded fields of other packages are not accessible.
+						continue
+					}
+				} else if !field.Exported() {
+					continue
+				}
+
+				tag := reflect.StructTag(e.st.Tag(i))
+				value, ok := tag.Lookup("go2xs")
+				if !ok {
+					value = tag.Get("json")
+				}
+				opts := strings.Split(value, ",")
+				if opts[0] == "-" && len(opts) == 1 {
+					continue
+				}
+
+				if embeddedStruct != nil && opts[0] == "" {
+					// promote the fields of the embedded struct
+					ptrs := e.ptrs
+					if isPtr {
+						ptrs = append(append([]embeddedPtr{}, e.ptrs...), embeddedPtr{name: name, elem: field.Type().(*types.Pointer).Elem()})
+					}
+					next = append(next, embedded{st: embeddedStruct, name: name, ptrs: ptrs})
+					continue
+				}
+
+				omitEmpty := false
+				for _, opt := range opts[1:] {
+					if opt == "omitempty" {
+						omitEmpty = true
+					}
+				}
+
+				key := opts[0]
+				if key == "" {
+					key = field.Name()
+				}
+				fields = append(fields, structField{
+					name:      name,
+					key:       key,
+					omitEmpty: omitEmpty,
+					typ:       field.Type(),
+					depth:     depth,
+					tagged:    opts[0] != "",
+					ptrs:      e.ptrs,
+				})
+			}
+		}
+	}
+	return dominantFields(fields)
+}
+
+// dominantFields removes the fields hidden by other fields of the same key.
+func dominantFields(fields []structField) []structField {
+	byKey := map[string][]structField{}
+	for _, f := range fields {
+		byKey[f.key] = append(byKey[f.key], f)
+	}
+
+	ret := make([]structField, 0, len(fields))
+	for _, f := range fields {
+		// count the rivals of the same depth. the deeper fields are hidden.
+		var n, tagged int
+		hidden := false
+		for _, g := range byKey[f.key] {
+			if g.depth < f.depth {
+				hidden = true
+			} else if g.depth == f.depth {
+				n++
+				if g.tagged {
+					tagged++
+				}
+			}
+		}
+		if hidden || (n > 1 && !(f.tagged && tagged == 1)) {
+			continue
+		}
+		ret = append(ret, f)
+	}
+	return ret
+}
+
+// structFromSV generates the function that converts hash references into the struct.
+func (c *converters) structFromSV(fname, typ string, st *types.Struct, strict bool) (string, bool) {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `func %s(sv unsafe.Pointer) (%s, error) {
+	var v %s
+	if !go2xsIsHashRef(sv) {
+		return v, go2xsTypeError("a HASH reference")
+	}
+`, fname, typ, typ)
+	for _, f := range structFields(c.pkg, st) {
+		conv, ok := c.fromSV(f.typ, strict)
+		if !ok {
+			return "", false
+		}
+		fmt.Fprintf(buf, `	if e := go2xsHVFetch(sv, %q); e != nil {
+		f, err := %s(e)
+		if err != nil {
+			return v, go2xsWrapPath(err, go2xsKeyPath(%q))
+		}
+`, f.key, conv, f.key)
+		for _, p := range f.ptrs {
+			// allocate the embedded structs promoting the field
+			fmt.Fprintf(buf, "\t\tif v.%s == nil {\n\t\t\tv.%s = new(%s)\n\t\t}\n", p.name, p.name, c.typeString(p.elem))
+		}
+		fmt.Fprintf(buf, "\t\tv.%s = f\n\t}\n", f.name)
+	}
+	fmt.Fprint(buf, "\treturn v, nil\n}\n")
+	return buf.String(), true
+}
+
+// structToSV generates the function that converts the struct into hash references.
+func (c *converters) structToSV(fname, typ string, st *types.Struct) (string, bool) {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `func %s(v %s) unsafe.Pointer {
+	rv := go2xsNewHVRef()
+`, fname, typ)
+	for _, f := range structFields(c.pkg, st) {
+		conv, ok := c.toSV(f.typ)
+		if !ok {
+			return "", false
+		}
+		store := fmt.Sprintf("go2xsHVStore(rv, %q, %s(v.%s))", f.key, conv, f.name)
+
+		// the fields of nil embedded pointers are omitted
+		var conds []string
+		for _, p := range f.ptrs {
+			conds = append(conds, "v."+p.name+" != nil")
+		}
+		if cond := nonEmpty(f.typ, "v."+f.name); f.omitEmpty && cond != "" {
+			conds = append(conds, cond)
+		}
+		if len(conds) > 0 {
+			fmt.Fprintf(buf, "\tif %s {\n\t\t%s\n\t}\n", strings.Join(conds, " && "), store)
+		} else {
+			fmt.Fprintf(buf, "\t%s\n", store)
+		}
+	}
+	fmt.Fprint(buf, "\treturn rv\n}\n")
+	return buf.String(), true
+}
+
+// nonEmpty returns the condition that the value is not empty for omitempty.
+// It returns "" if the value is never omitted.
+func nonEmpty(t types.Type, v string) string {
+	switch t := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case t.Info()&types.IsBoolean != 0:
+			return v
+		case t.Info()&types.IsString != 0:
+			return v + ` != ""`
+		}
+		return v + " != 0"
+	case *types.Slice, *types.Map:
+		return "len(" + v + ") != 0"
+	case *types.Pointer, *types.Signature:
+		return v + " != nil"
+	}
+	return ""
+}
+
+// fail unregisters the function that cannot be generated.
+func (c *converters) fail(name string) (string, bool) {
+	delete(c.funcs, name)
+	for i, n := range c.names {
+		if n == name {
+			c.names = append(c.names[:i], c.names[i+1:]...)
+			break
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,167 @@
+package go2xs
+
+import (
+	"fmt"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// directive is the go2xs directive of the function, e.g. "//go2xs name strict context=scalar".
+// The first field is the Perl name of the function, and the others are options.
+// Options are flags or key=value pairs.
+// The bare directive, or the directive starting with key=value options, names the function after the Go function.
+// The directive starting with a flag such as "//go2xs strict" is ambiguous, and reported as an error.
+type directive struct {
+	// name is the name of the function given by the directive.
+	// it may be qualified by the Perl package, e.g. "Acme::Foo::parse".
+	// it is empty if the directive is bare, or starts with key=value options.
+	name string
+
+	// noescape, strict, nopanic and async are same as the fields of FuncGenerator.
+	noescape bool
+	strict   bool
+	nopanic  bool
+	async    bool
+
+	// context is how the results are returned, "list" or "scalar".
+	// "list" pushes all results on the Perl stack.
+	// "scalar" returns one value, multiple results are returned as an array reference.
+	context string
+
+	// proto is the prototype of the Perl function, if hasProto is true.
+	proto    string
+	hasProto bool
+
+	// exports are the export tags of the function without leading colons, e.g. "all" of "export=:all".
+	exports []string
+}
+
+var (
+	protoPattern     = regexp.MustCompile(`^[$@%&*;\\\[\]_+]*$`)
+	exportTagPattern = regexp.MustCompile(`^:[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// findDirective returns the comment of the go2xs directive, or nil if the function doesn't have it.
+func findDirective(doc *ast.CommentGroup) *ast.Comment {
+	if doc == nil {
+		return nil
+	}
+	for _, c := range doc.List {
+		if c.Text == "//go2xs" || strings.HasPrefix(c.Text, "//go2xs ") || strings.HasPrefix(c.Text, "//go2xs\t") {
+			return c
+		}
+	}
+	return nil
+}
+
+// directiveField is a field of the directive, and its offset from the beginning of the comment.
+type directiveField struct {
+	text   string
+	offset int
+}
+
+func splitDirective(text string) []directiveField {
+	var fields []directiveField
+	start := -1
+	for i := 0; i <= len(text); i++ {
+		if i == len(text) || text[i] == ' ' || text[i] == '\t' {
+			if start >= 0 {
+				fields = append(fields, directiveField{text: text[start:i], offset: start})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	return fields
+}
+
+// isDirectiveFlag reports whether the option is a flag, which can be written without the value.
+func isDirectiveFlag(key string) bool {
+	switch key {
+	case "noescape", "strict", "nopanic", "async":
+		return true
+	}
+	return false
+}
+
+// parseDirective parses the go2xs directive.
+// It returns scanner.ErrorList with the positions of unknown options or invalid values.
+func parseDirective(fset *token.FileSet, c *ast.Comment) (directive, scanner.ErrorList) {
+	var d directive
+	var errs scanner.ErrorList
+	errorf := func(f directiveField, format string, args ...interface{}) {
+		var pos token.Position
+		if fset != nil {
+			pos = fset.Position(c.Slash + token.Pos(f.offset))
+		}
+		errs.Add(pos, fmt.Sprintf(format, args...))
+	}
+
+	fields := splitDirective(c.Text)[1:] // skip "//go2xs"
+	if len(fields) > 0 && !strings.Contains(fields[0].text, "=") {
+		if name := fields[0].text; isDirectiveFlag(name) {
+			// "//go2xs strict" may be either the name or the option
+			errorf(fields[0], "ambiguous go2xs directive: use %s=true for the option, or give the name before it", name)
+			return d, errs
+		}
+		d.name = fields[0].text
+		fields = fields[1:]
+	}
+	for _, f := range fields {
+		key, value, hasValue := strings.Cut(f.text, "=")
+		switch {
+		case isDirectiveFlag(key):
+			b := true
+			if hasValue {
+				var err error
+				if b, err = strconv.ParseBool(value); err != nil {
+					errorf(f, "invalid value %q of go2xs option %s: want true or false", value, key)
+					continue
+				}
+			}
+			switch key {
+			case "noescape":
+				d.noescape = b
+			case "strict":
+				d.strict = b
+			case "nopanic":
+				d.nopanic = b
+			case "async":
+				d.async = b
+			}
+		case key == "context":
+			if value != "list" && value != "scalar" {
+				errorf(f, "invalid value %q of go2xs option context: want list or scalar", value)
+				continue
+			}
+			d.context = value
+		case key == "proto":
+			if !hasValue || !protoPattern.MatchString(value) {
+				errorf(f, "invalid value %q of go2xs option proto: want a prototype such as $$", value)
+				continue
+			}
+			d.proto = value
+			d.hasProto = true
+		case key == "export":
+			if !hasValue {
+				errorf(f, "go2xs option export requires tags such as export=:tag")
+				continue
+			}
+			for _, tag := range strings.Split(value, ",") {
+				if !exportTagPattern.MatchString(tag) {
+					errorf(f, "invalid value %q of go2xs option export: want tags such as :tag", tag)
+					continue
+				}
+				d.exports = append(d.exports, tag[1:])
+			}
+		default:
+			errorf(f, "unknown go2xs option %q", key)
+		}
+	}
+	return d, errs
+}
@@ -4,12 +4,52 @@ import (
 	"bytes"
 	"fmt"
 	"go/ast"
+	"go/scanner"
+	"go/token"
+	"go/types"
 	"strings"
 )
 
 type FuncGenerator struct {
 	xsName string
 	fd     *ast.FuncDecl
+	sig    *types.Signature
+
+	// pkg is the Perl package of the function given by the fully qualified name, e.g. "//go2xs Acme::Foo::Util::parse".
+	// it is empty if the function belongs to the package of the module.
+	pkg string
+
+	// fset is the file set of fd, for reporting errors with positions.
+	fset *token.FileSet
+
+	// errs are the errors found while generating the glue code.
+	errs scanner.ErrorList
+
+	// directive is the options given by the go2xs directive.
+	directive directive
+
+	// noescape is true if the Go function doesn't retain its parameters.
+	// byte slices are passed without copying.
+	noescape bool
+
+	// strict is true if numbers are checked strictly.
+	// numbers out of range and non-numeric strings are not converted silently, but croak.
+	strict bool
+
+	// nopanic is true if the Go function never panics.
+	// panics are not recovered, and crash the process.
+	nopanic bool
+
+	// class is the name of Go type, if the function is a method or a constructor of the Perl class.
+	class string
+
+	// serve is true if the parameters or the receiver may hold Perl callbacks.
+	// The Go function runs in another goroutine, while the Perl thread runs the callbacks.
+	serve bool
+
+	// stackOffset is the index of the first parameter on the Perl stack.
+	// methods take the object, and constructors take the class name first.
+	stackOffset int
 
 	xsBefore *bytes.Buffer
 	xsAfter  *bytes.Buffer
@@ -32,31 +72,86 @@ type FuncGenerator struct {
 	xsResults []string
 
 	numXsReturn int
+
+	// conv generates converters between SV and Go values
+	conv *converters
+
+	// mayCroak is true if the Go glue code may return an error
+	mayCroak bool
+
+	// async is true if the Go function runs in background.
+	// the XSUB returns the handle of the job, and the results are converted by its result method.
+	async bool
+
+	// asyncResult is the Go code that converts the results of the async function into SVs.
+	asyncResult string
+}
+
+// splitXSName splits the fully qualified name into the package and the function name,
+// e.g. "Acme::Foo::parse" into "Acme::Foo" and "parse".
+func splitXSName(name string) (pkg, xsName string) {
+	i := strings.LastIndex(name, "::")
+	if i < 0 {
+		return "", name
+	}
+	return name[:i], name[i+2:]
 }
 
-func getXSName(doc *ast.CommentGroup) string {
-	if doc == nil {
+// receiverType returns the type name of the method receiver
+func receiverType(sig *types.Signature) string {
+	if sig.Recv() == nil {
 		return ""
 	}
+	t := types.Unalias(sig.Recv().Type())
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = types.Unalias(ptr.Elem())
+	}
+	if named, ok := t.(*types.Named); ok {
+		return named.Obj().Name()
+	}
+	return ""
+}
 
-	for _, item := range doc.List {
-		l := strings.Split(item.Text, " ")
-		if len(l) >= 2 && l[0] == "//go2xs" {
-			return l[1]
+// constructorType returns the type name T, if the function returns *T of the same package.
+func constructorType(fn *types.Func) string {
+	results := fn.Type().(*types.Signature).Results()
+	if results.Len() == 0 {
+		return ""
+	}
+	if ptr, ok := types.Unalias(results.At(0).Type()).(*types.Pointer); ok {
+		if named, ok := types.Unalias(ptr.Elem()).(*types.Named); ok && named.Obj().Pkg() == fn.Pkg() {
+			return named.Obj().Name()
 		}
 	}
 	return ""
 }
 
-func NewFuncGenerator(fd *ast.FuncDecl) *FuncGenerator {
-	xsName := getXSName(fd.Doc)
-	if xsName == "" {
+// NewFuncGenerator returns the generator of the function, or nil if the function doesn't have the go2xs directive.
+// The errors of the directive are reported by Generate.
+func NewFuncGenerator(fset *token.FileSet, fd *ast.FuncDecl, fn *types.Func) *FuncGenerator {
+	c := findDirective(fd.Doc)
+	if c == nil {
 		return nil
 	}
+	d, errs := parseDirective(fset, c)
+	pkg, xsName := splitXSName(d.name)
+	if d.name == "" {
+		// the bare directive exports the function by the Go name
+		xsName = fd.Name.Name
+	}
 
-	return &FuncGenerator{
+	fg := &FuncGenerator{
 		xsName:           xsName,
+		pkg:              pkg,
 		fd:               fd,
+		sig:              fn.Type().(*types.Signature),
+		fset:             fset,
+		errs:             errs,
+		directive:        d,
+		noescape:         d.noescape,
+		strict:           d.strict,
+		nopanic:          d.nopanic,
+		async:            d.async,
 		xsBefore:         &bytes.Buffer{},
 		xsAfter:          &bytes.Buffer{},
 		goBefore:         &bytes.Buffer{},
@@ -65,33 +160,206 @@ func NewFuncGenerator(fd *ast.FuncDecl) *FuncGenerator {
 		goParams:         []string{},
 		xsParams:         []string{},
 		numXsReturn:      0,
+		conv:             newConverters(),
+	}
+	if class := receiverType(fg.sig); class != "" {
+		fg.class = class
+		fg.stackOffset = 1
+	} else if class := constructorType(fn); class != "" && pkg == "" && xsName == "new" {
+		// "//go2xs new" makes the constructor of the class
+		fg.class = class
+		fg.stackOffset = 1
+	}
+	if fg.class != "" {
+		fg.conv.classes[fg.class] = true
+	}
+	if fg.async {
+		// the goroutine may use the parameters after the XSUB returns
+		fg.noescape = false
+	} else {
+		seen := map[types.Type]bool{}
+		if recv := fg.sig.Recv(); recv != nil {
+			fg.serve = mayHoldCallbacks(recv.Type(), seen)
+		}
+		for i := 0; i < fg.sig.Params().Len(); i++ {
+			fg.serve = fg.serve || mayHoldCallbacks(fg.sig.Params().At(i).Type(), seen)
+		}
+	}
+	return fg
+}
+
+// mayHoldCallbacks reports whether the values of the type may hold functions.
+// Interfaces and type parameters may hold any values.
+func mayHoldCallbacks(t types.Type, seen map[types.Type]bool) bool {
+	if seen[t] {
+		return false
 	}
+	seen[t] = true
+	switch t := types.Unalias(t).(type) {
+	case *types.Signature, *types.Interface, *types.TypeParam:
+		return true
+	case *types.Named:
+		return mayHoldCallbacks(t.Underlying(), seen)
+	case *types.Pointer:
+		return mayHoldCallbacks(t.Elem(), seen)
+	case *types.Slice:
+		return mayHoldCallbacks(t.Elem(), seen)
+	case *types.Array:
+		return mayHoldCallbacks(t.Elem(), seen)
+	case *types.Chan:
+		return mayHoldCallbacks(t.Elem(), seen)
+	case *types.Map:
+		return mayHoldCallbacks(t.Key(), seen) || mayHoldCallbacks(t.Elem(), seen)
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if mayHoldCallbacks(t.Field(i).Type(), seen) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (fg *FuncGenerator) Generate() {
-	fmt.Fprintf(fg.xsBefore, `void
-%s (...)
-    PPCODE:
+	if !identPattern.MatchString(fg.xsName) {
+		fg.errorf(fg.fd.Doc.Pos(), "%s: invalid function name %q", fg.fd.Name.Name, fg.xsName)
+	}
+	if fg.pkg != "" {
+		if !packagePattern.MatchString(fg.pkg) {
+			fg.errorf(fg.fd.Doc.Pos(), "%s: invalid package name %q", fg.fd.Name.Name, fg.pkg)
+		}
+		if fg.sig.Recv() != nil {
+			fg.errorf(fg.fd.Doc.Pos(), "%s: methods cannot be placed in other packages", fg.fd.Name.Name)
+		}
+	}
+
+	params := tupleVars(fg.sig.Params(), "param")
+	names := make([]string, 0, len(params)+1)
+	if fg.sig.Recv() != nil {
+		names = append(names, "self")
+	} else if fg.class != "" {
+		names = append(names, "CLASS")
+	}
+	for _, p := range params {
+		names = append(names, p.name)
+	}
+	fmt.Fprintf(fg.xsBefore, "void\n%s (...)\n", fg.xsName)
+	if fg.directive.hasProto {
+		fmt.Fprintf(fg.xsBefore, "    PROTOTYPE: %s\n", fg.directive.proto)
+	}
+	fmt.Fprintf(fg.xsBefore, `    PPCODE:
+    if (items != %d)
+        croak_xs_usage(cv, %q);
 {
-`, fg.xsName)
+`, len(names), strings.Join(names, ", "))
 
-	if params := fg.fd.Type.Params; params != nil {
-		for i, p := range params.List {
-			fg.addParam(i, p)
+	if !fg.nopanic {
+		// convert panics into Perl exceptions
+		fmt.Fprint(fg.goBefore, "defer func() {\nif r := recover(); r != nil {\n")
+		fmt.Fprintf(fg.goBefore, "errSV = go2xsPanicError(%q, r)\n", fg.fd.Name.Name)
+		fmt.Fprint(fg.goBefore, "}\n}()\n")
+		fg.mayCroak = true
+	}
+
+	if fg.sig.Recv() != nil {
+		fg.addReceiver()
+	} else if fg.class != "" {
+		fg.addClass()
+	}
+	paramExprs := fieldExprs(fg.fd.Type.Params)
+	for i, p := range params {
+		if !fg.addParam(i, p) {
+			fg.unsupported("parameter", paramExprs[i])
 		}
 	}
+	if fg.sig.Variadic() && len(fg.errs) == 0 {
+		fg.goParams[len(fg.goParams)-1] += "..."
+	}
 
-	if results := fg.fd.Type.Results; results != nil {
-		for i, r := range results.List {
-			fg.addResult(i, r)
+	results := tupleVars(fg.sig.Results(), "result")
+	resultExprs := fieldExprs(fg.fd.Type.Results)
+	if fg.async {
+		fg.addAsyncResults(results, resultExprs)
+	} else {
+		list := results
+		if n := len(list); n > 0 && isErrorType(list[n-1].typ) {
+			// check the error before converting other results
+			list = list[:n-1]
+			fg.addResultError(n - 1)
+		}
+		for i, r := range list {
+			if i == 0 && fg.isConstructor() {
+				fg.addResultObject(i)
+				continue
+			}
+			if !fg.addResult(i, r) {
+				fg.unsupported("result", resultExprs[i])
+			}
 		}
+		if len(list) < len(results) {
+			fg.goResults = append(fg.goResults, fmt.Sprintf("goresult%d", len(list)))
+		}
+	}
+
+	if fg.mayCroak {
+		fg.goGlueResultDecls = append(fg.goGlueResultDecls, "errSV unsafe.Pointer")
+		fg.xsResults = append(fg.xsResults, "errSV")
+		fmt.Fprint(fg.xsBefore, "SV* errSV;\n")
 	}
 
-	fmt.Fprintf(fg.xsAfter, "XSRETURN(%d);\n", fg.numXsReturn)
+	if fg.directive.context == "scalar" && fg.numXsReturn > 1 {
+		// return the results as an array reference
+		fmt.Fprintf(fg.xsAfter, "{\nAV* av = av_make(%d, SP - %d);\nSP -= %d;\nXPUSHs(sv_2mortal(newRV_noinc((SV*)av)));\n}\n", fg.numXsReturn, fg.numXsReturn-1, fg.numXsReturn)
+		fmt.Fprint(fg.xsAfter, "XSRETURN(1);\n")
+	} else {
+		fmt.Fprintf(fg.xsAfter, "XSRETURN(%d);\n", fg.numXsReturn)
+	}
 	fmt.Fprint(fg.xsAfter, "}\n\n")
 	fmt.Fprint(fg.goAfter, "return\n")
 }
 
+// unsupported reports the type of the parameter or the result that cannot be converted.
+func (fg *FuncGenerator) unsupported(kind string, expr ast.Expr) {
+	fg.errorf(expr.Pos(), "%s: unsupported %s type %s", fg.fd.Name.Name, kind, types.ExprString(expr))
+}
+
+// position returns the position of the Go function.
+func (fg *FuncGenerator) position() token.Position {
+	if fg.fset == nil {
+		return token.Position{}
+	}
+	return fg.fset.Position(fg.fd.Name.Pos())
+}
+
+// perlName returns the fully qualified Perl name of the function in the module.
+// If module is empty, the name is relative to the module.
+func (fg *FuncGenerator) perlName(module string) string {
+	var name string
+	switch {
+	case fg.class != "":
+		name = module + "::" + fg.class + "::" + fg.xsName
+	case fg.pkg != "":
+		return fg.pkg + "::" + fg.xsName
+	default:
+		name = module + "::" + fg.xsName
+	}
+	return strings.TrimPrefix(name, "::")
+}
+
+// errorf reports the error at the position.
+func (fg *FuncGenerator) errorf(p token.Pos, format string, args ...interface{}) {
+	var pos token.Position
+	if fg.fset != nil {
+		pos = fg.fset.Position(p)
+	}
+	fg.errs.Add(pos, fmt.Sprintf(format, args...))
+}
+
+// Errors returns the errors found by Generate, or nil if the glue code is generated successfully.
+func (fg *FuncGenerator) Errors() error {
+	return fg.errs.Err()
+}
+
 // Glue code written in XS
 func (fg *FuncGenerator) XSCode() string {
 	return fg.xsBefore.String() + fg.xsCall() + fg.xsAfter.String()
@@ -102,10 +370,24 @@ func (fg *FuncGenerator) GoCode() string {
 	return fg.goGlueDecl() + "{\n" + fg.goBefore.String() + fg.goCall() + fg.goAfter.String() + "}\n"
 }
 
+// Name of Go glue code.
+// The prefixes go2xsf_ for functions, go2xsp_ for functions of other packages, and go2xsm_ for methods
+// separate them from each other, and from the fixed exports such as go2xsBoot.
+func (fg *FuncGenerator) goGlueName() string {
+	if fg.class != "" {
+		return "go2xsm_" + fg.class + "_" + fg.xsName
+	}
+	if fg.pkg != "" {
+		return "go2xsp_" + libraryName(fg.pkg) + "_" + fg.xsName
+	}
+	return "go2xsf_" + fg.xsName
+}
+
 // Declaration for Go glue code
 func (fg *FuncGenerator) goGlueDecl() string {
-	decl := "//export go2xs" + fg.xsName + "\n" +
-		"func go2xs" + fg.xsName + "(" + strings.Join(fg.goGlueParamDecls, ", ") + ") "
+	name := fg.goGlueName()
+	decl := "//export " + name + "\n" +
+		"func " + name + "(" + strings.Join(fg.goGlueParamDecls, ", ") + ") "
 	if len(fg.goGlueResultDecls) > 0 {
 		decl += "(" + strings.Join(fg.goGlueResultDecls, ", ") + ")"
 	}
@@ -115,31 +397,103 @@ func (fg *FuncGenerator) goGlueDecl() string {
 // Go code for calling original Go function
 func (fg *FuncGenerator) goCall() string {
 	call := fg.fd.Name.Name + "(" + strings.Join(fg.goParams, ", ") + ")\n"
-	if len(fg.goResults) == 0 {
+	if fg.sig.Recv() != nil {
+		call = "self." + call
+	}
+	if fg.async {
+		if len(fg.goResults) > 0 {
+			call = strings.Join(fg.goResults, ", ") + " := " + call
+		}
+		return fmt.Sprintf("job := go2xsStartJob(%q, %t, func() func() ([]unsafe.Pointer, unsafe.Pointer) {\n", fg.fd.Name.Name, !fg.nopanic) +
+			call +
+			"return func() (results []unsafe.Pointer, errSV unsafe.Pointer) {\n" + fg.asyncResult + "return\n}\n" +
+			"})\n" +
+			"result0 = go2xsNewObject(go2xsModule+\"::Async\", job)\n"
+	}
+	if !fg.serve {
+		if len(fg.goResults) > 0 {
+			call = strings.Join(fg.goResults, ", ") + " := " + call
+		}
 		return call
-	} else {
-		return strings.Join(fg.goResults, ", ") + " := " + call
 	}
+	// the Go function runs in another goroutine, and the glue code runs the callbacks called from any goroutine.
+	// Perl callbacks may be held by the parameters, the receiver, or the values stored in the receiver before the call.
+	var decls string
+	for i, r := range tupleVars(fg.sig.Results(), "result") {
+		decls += fmt.Sprintf("var goresult%d %s\n", i, fg.conv.typeString(r.typ))
+	}
+	if len(fg.goResults) > 0 {
+		call = strings.Join(fg.goResults, ", ") + " = " + call
+	}
+	return decls + "go2xsServe(func() {\n" + call + "})\n"
 }
 
 func (fg *FuncGenerator) xsCall() string {
-	call := "go2xs" + fg.xsName + "(" + strings.Join(fg.xsParams, ", ") + ");\n"
-	if len(fg.xsResults) == 0 {
-		return call
-	}
+	call := fg.goGlueName() + "(" + strings.Join(fg.xsParams, ", ") + ");\n"
 	if len(fg.xsResults) == 1 {
-		return fg.xsResults[0] + " = " + call
+		call = fg.xsResults[0] + " = " + call
+	} else if len(fg.xsResults) > 1 {
+		for i, name := range fg.xsResults {
+			call += fmt.Sprintf("%s = result.r%d;\n", name, i)
+		}
+		call = "struct " + fg.goGlueName() + "_return result = " + call
 	}
+	// the Go function may call back into Perl, and Perl may reallocate the stack.
+	call = "PUTBACK;\n" + call + "SPAGAIN;\n"
+	if fg.mayCroak {
+		call += "if (errSV) croak(\"%\" SVf, SVfARG(sv_2mortal(errSV)));\n"
+	}
+	return call
+}
 
-	for i, name := range fg.xsResults {
-		call += fmt.Sprintf("%s = result.r%d;\n", name, i)
+// st returns the expression of the parameter on the Perl stack
+func (fg *FuncGenerator) st(index int) string {
+	return fmt.Sprintf("ST(%d)", index+fg.stackOffset)
+}
+
+// addReceiver converts the Perl object into the receiver of the method
+func (fg *FuncGenerator) addReceiver() {
+	recv := fg.sig.Recv().Type()
+	if _, ok := types.Unalias(recv).(*types.Pointer); !ok {
+		recv = types.NewPointer(recv)
 	}
-	return "struct go2xs" + fg.xsName + "_return result = " + call
+	conv, _ := fg.conv.fromSV(recv, false)
+	fg.goGlueParamDecls = append(fg.goGlueParamDecls, "selfSV unsafe.Pointer")
+	fg.xsParams = append(fg.xsParams, "ST(0)")
+	fmt.Fprintf(fg.goBefore, "self, err := %s(selfSV)\n", conv)
+	fmt.Fprintf(fg.goBefore, "if err == nil && self == nil {\nerr = go2xsTypeError(\"a \" + go2xsModule + %q)\n}\n", "::"+fg.class+" object")
+	fmt.Fprint(fg.goBefore, "if err != nil {\nerrSV = go2xsNewError(go2xsWrapPath(err, \"self\"))\nreturn\n}\n")
+	fg.mayCroak = true
+}
+
+// isConstructor reports whether the function is the constructor of the Perl class.
+func (fg *FuncGenerator) isConstructor() bool {
+	return fg.class != "" && fg.sig.Recv() == nil
+}
+
+// addClass passes the class name to the constructor, so subclasses can inherit it.
+func (fg *FuncGenerator) addClass() {
+	fg.goGlueParamDecls = append(fg.goGlueParamDecls, "classSV unsafe.Pointer")
+	fg.xsParams = append(fg.xsParams, "ST(0)")
+	fmt.Fprintf(fg.goBefore, "class, err := go2xsClassName(classSV, go2xsModule+%q)\n", "::"+fg.class)
+	fmt.Fprint(fg.goBefore, "if err != nil {\nerrSV = go2xsNewError(go2xsWrapPath(err, \"CLASS\"))\nreturn\n}\n")
+	fg.mayCroak = true
 }
 
-func (fg *FuncGenerator) addParam(index int, param *ast.Field) {
-	if ident, ok := param.Type.(*ast.Ident); ok {
-		switch ident.Name {
+// addParam converts the parameter. It returns false if the type is not supported.
+func (fg *FuncGenerator) addParam(index int, param variable) bool {
+	switch t := types.Unalias(param.typ).(type) {
+	case *types.Basic:
+		name := basicName(t)
+		if _, ok := strictRanges[name]; ok && fg.strict {
+			// check the range in the converter
+			conv, ok := fg.conv.fromSV(t, true)
+			if ok {
+				fg.addParamSV(index, param.name, conv)
+			}
+			return ok
+		}
+		switch name {
 		case "int8":
 			fg.addParamPrimitive(index, "int8", "GoInt8", "SvIV")
 		case "uint8":
@@ -164,10 +518,40 @@ func (fg *FuncGenerator) addParam(index int, param *ast.Field) {
 			fg.addParamPrimitive(index, "float32", "GoFloat32", "SvNV")
 		case "float64":
 			fg.addParamPrimitive(index, "float64", "GoFloat64", "SvNV")
+		case "bool":
+			fg.addParamBool(index)
 		case "string":
 			fg.addParamString(index)
+		default:
+			return false
+		}
+		return true
+	case *types.Slice:
+		if isByteSlice(t) {
+			fg.addParamBytes(index)
+			return true
 		}
 	}
+
+	// named types are converted via their underlying types
+	conv, ok := fg.conv.fromSV(param.typ, fg.strict)
+	if ok {
+		// undef is nil in arrays and hashes, but the parameters must be references
+		switch param.typ.Underlying().(type) {
+		case *types.Slice:
+			fg.addParamDefined(index, param.name, "an ARRAY reference")
+		case *types.Map:
+			fg.addParamDefined(index, param.name, "a HASH reference")
+		}
+		fg.addParamSV(index, param.name, conv)
+	}
+	return ok
+}
+
+// addParamDefined croaks if the parameter is undef.
+func (fg *FuncGenerator) addParamDefined(index int, name, expected string) {
+	fmt.Fprintf(fg.goBefore, "if !go2xsSVOK(param%dSV) {\nerrSV = go2xsNewError(go2xsWrapPath(go2xsTypeError(%q), %q))\nreturn\n}\n", index, expected, name)
+	fg.mayCroak = true
 }
 
 // addParamPrimitive converts XS primitive types
@@ -175,7 +559,15 @@ func (fg *FuncGenerator) addParamPrimitive(index int, goType, xsType, svType str
 	fg.goGlueParamDecls = append(fg.goGlueParamDecls, fmt.Sprintf("param%d %s", index, goType))
 	fg.goParams = append(fg.goParams, fmt.Sprintf("param%d", index))
 	fg.xsParams = append(fg.xsParams, fmt.Sprintf("param%d", index))
-	fmt.Fprintf(fg.xsBefore, "%s param%d = (%s)%s(ST(%d));\n", xsType, index, xsType, svType, index)
+	fmt.Fprintf(fg.xsBefore, "%s param%d = (%s)%s(%s);\n", xsType, index, xsType, svType, fg.st(index))
+}
+
+// addParamBool converts the truthiness of SV into Go bool
+func (fg *FuncGenerator) addParamBool(index int) {
+	fg.goGlueParamDecls = append(fg.goGlueParamDecls, fmt.Sprintf("param%d bool", index))
+	fg.goParams = append(fg.goParams, fmt.Sprintf("param%d", index))
+	fg.xsParams = append(fg.xsParams, fmt.Sprintf("param%d", index))
+	fmt.Fprintf(fg.xsBefore, "GoUint8 param%d = SvTRUE(%s) ? 1 : 0;\n", index, fg.st(index))
 }
 
 func (fg *FuncGenerator) addParamString(index int) {
@@ -184,13 +576,42 @@ func (fg *FuncGenerator) addParamString(index int) {
 	fg.xsParams = append(fg.xsParams, fmt.Sprintf("param%dPtr", index), fmt.Sprintf("param%dLen", index))
 	fmt.Fprintf(fg.goBefore, "param%d := C.GoStringN(param%dPtr, param%dLen)\n", index, index, index)
 	fmt.Fprintf(fg.xsBefore, "STRLEN param%dStrlen;\n", index)
-	fmt.Fprintf(fg.xsBefore, "char* param%dPtr = SvPV(ST(%d), param%dStrlen);\n", index, index, index)
+	fmt.Fprintf(fg.xsBefore, "char* param%dPtr = SvPV(%s, param%dStrlen);\n", index, fg.st(index), index)
+	fmt.Fprintf(fg.xsBefore, "int param%dLen = (int)param%dStrlen;\n", index, index)
+}
+
+// addParamBytes converts byte strings into []byte.
+// The string is not decoded from UTF-8.
+func (fg *FuncGenerator) addParamBytes(index int) {
+	fg.goGlueParamDecls = append(fg.goGlueParamDecls, fmt.Sprintf("param%dPtr unsafe.Pointer", index), fmt.Sprintf("param%dLen C.int", index))
+	fg.goParams = append(fg.goParams, fmt.Sprintf("param%d", index))
+	fg.xsParams = append(fg.xsParams, fmt.Sprintf("param%dPtr", index), fmt.Sprintf("param%dLen", index))
+	if fg.noescape {
+		// refer the buffer of SV directly
+		fmt.Fprintf(fg.goBefore, "param%d := unsafe.Slice((*byte)(param%dPtr), int(param%dLen))\n", index, index, index)
+	} else {
+		fmt.Fprintf(fg.goBefore, "param%d := C.GoBytes(param%dPtr, param%dLen)\n", index, index, index)
+	}
+	fmt.Fprintf(fg.xsBefore, "STRLEN param%dStrlen;\n", index)
+	fmt.Fprintf(fg.xsBefore, "char* param%dPtr = SvPVbyte(%s, param%dStrlen);\n", index, fg.st(index), index)
 	fmt.Fprintf(fg.xsBefore, "int param%dLen = (int)param%dStrlen;\n", index, index)
 }
 
-func (fg *FuncGenerator) addResult(index int, result *ast.Field) {
-	if ident, ok := result.Type.(*ast.Ident); ok {
-		switch ident.Name {
+// addParamSV passes SV to Go, and converts it by the converter function
+func (fg *FuncGenerator) addParamSV(index int, name, conv string) {
+	fg.goGlueParamDecls = append(fg.goGlueParamDecls, fmt.Sprintf("param%dSV unsafe.Pointer", index))
+	fg.goParams = append(fg.goParams, fmt.Sprintf("param%d", index))
+	fg.xsParams = append(fg.xsParams, fg.st(index))
+	fmt.Fprintf(fg.goBefore, "param%d, err := %s(param%dSV)\n", index, conv, index)
+	fmt.Fprintf(fg.goBefore, "if err != nil {\nerrSV = go2xsNewError(go2xsWrapPath(err, %q))\nreturn\n}\n", name)
+	fg.mayCroak = true
+}
+
+// addResult converts the result. It returns false if the type is not supported.
+func (fg *FuncGenerator) addResult(index int, result variable) bool {
+	switch t := types.Unalias(result.typ).(type) {
+	case *types.Basic:
+		switch basicName(t) {
 		case "int8":
 			fg.addResultPrimitive(index, "int8", "GoInt8", "newSViv")
 		case "uint8":
@@ -215,10 +636,27 @@ func (fg *FuncGenerator) addResult(index int, result *ast.Field) {
 			fg.addResultPrimitive(index, "float32", "GoFloat32", "newSVnv")
 		case "float64":
 			fg.addResultPrimitive(index, "float64", "GoFloat64", "newSVnv")
+		case "bool":
+			fg.addResultBool(index)
 		case "string":
 			fg.addResultString(index)
+		default:
+			return false
 		}
+		return true
+	case *types.Slice:
+		if isByteSlice(t) {
+			fg.addResultBytes(index)
+			return true
+		}
+	}
+
+	// named types are converted via their underlying types
+	conv, ok := fg.conv.toSV(result.typ)
+	if ok {
+		fg.addResultSV(index, conv)
 	}
+	return ok
 }
 
 func (fg *FuncGenerator) addResultPrimitive(index int, goType, xsType, svType string) {
@@ -231,14 +669,152 @@ func (fg *FuncGenerator) addResultPrimitive(index int, goType, xsType, svType st
 	fg.numXsReturn++
 }
 
+func (fg *FuncGenerator) addResultBool(index int) {
+	fg.goGlueResultDecls = append(fg.goGlueResultDecls, fmt.Sprintf("result%d bool", index))
+	fg.goResults = append(fg.goResults, fmt.Sprintf("goresult%d", index))
+	fg.xsResults = append(fg.xsResults, fmt.Sprintf("result%d", index))
+	fmt.Fprintf(fg.goAfter, "result%d = goresult%d\n", index, index)
+	fmt.Fprintf(fg.xsBefore, "GoUint8 result%d;\n", index)
+	fmt.Fprintf(fg.xsAfter, "XPUSHs(result%d ? &PL_sv_yes : &PL_sv_no);\n", index)
+	fg.numXsReturn++
+}
+
+// addResultString creates the SV from the Go string directly.
+// No C string is allocated, so there is nothing to free.
 func (fg *FuncGenerator) addResultString(index int) {
-	fg.goGlueResultDecls = append(fg.goGlueResultDecls, fmt.Sprintf("result%dPtr *C.char", index), fmt.Sprintf("result%dLen C.int", index))
+	fg.addResultSV(index, "go2xsNewSVString")
+}
+
+// addResultBytes converts []byte into byte strings.
+// The result SV doesn't have UTF-8 flag.
+func (fg *FuncGenerator) addResultBytes(index int) {
+	fg.addResultSV(index, "go2xsNewSVBytes")
+}
+
+// addResultSV converts the result into a new SV by the converter function
+func (fg *FuncGenerator) addResultSV(index int, conv string) {
+	fg.goGlueResultDecls = append(fg.goGlueResultDecls, fmt.Sprintf("result%d unsafe.Pointer", index))
+	fg.goResults = append(fg.goResults, fmt.Sprintf("goresult%d", index))
+	fg.xsResults = append(fg.xsResults, fmt.Sprintf("result%d", index))
+	fmt.Fprintf(fg.goAfter, "result%d = %s(goresult%d)\n", index, conv, index)
+	fmt.Fprintf(fg.xsBefore, "SV* result%d;\n", index)
+	fmt.Fprintf(fg.xsAfter, "XPUSHs(sv_2mortal(result%d));\n", index)
+	fg.numXsReturn++
+}
+
+// addResultObject blesses the result of the constructor into the class given by the caller.
+func (fg *FuncGenerator) addResultObject(index int) {
+	fg.goGlueResultDecls = append(fg.goGlueResultDecls, fmt.Sprintf("result%d unsafe.Pointer", index))
 	fg.goResults = append(fg.goResults, fmt.Sprintf("goresult%d", index))
-	fg.xsResults = append(fg.xsResults, fmt.Sprintf("result%dPtr", index), fmt.Sprintf("result%dLen", index))
-	fmt.Fprintf(fg.goAfter, "result%dPtr = C.CString(goresult%d)\n", index, index)
-	fmt.Fprintf(fg.goAfter, "result%dLen = C.int(len(goresult%d))\n", index, index)
-	fmt.Fprintf(fg.xsBefore, "char* result%dPtr;\n", index)
-	fmt.Fprintf(fg.xsBefore, "int result%dLen;\n", index)
-	fmt.Fprintf(fg.xsAfter, "XPUSHs(sv_2mortal(newSVpvn(result%dPtr, result%dLen)));\n", index, index)
+	fg.xsResults = append(fg.xsResults, fmt.Sprintf("result%d", index))
+	fmt.Fprintf(fg.goAfter, "result%d = go2xsNewUndef()\n", index)
+	fmt.Fprintf(fg.goAfter, "if goresult%d != nil {\nresult%d = go2xsNewObject(class, goresult%d)\n}\n", index, index, index)
+	fmt.Fprintf(fg.xsBefore, "SV* result%d;\n", index)
+	fmt.Fprintf(fg.xsAfter, "XPUSHs(sv_2mortal(result%d));\n", index)
+	fg.numXsReturn++
+}
+
+// addAsyncResults returns the handle of the job, instead of the results.
+// The results are converted into SVs when the result method of the handle is called.
+func (fg *FuncGenerator) addAsyncResults(results []variable, exprs []ast.Expr) {
+	fg.goGlueResultDecls = append(fg.goGlueResultDecls, "result0 unsafe.Pointer")
+	fg.xsResults = append(fg.xsResults, "result0")
+	fmt.Fprint(fg.xsBefore, "SV* result0;\n")
+	fmt.Fprint(fg.xsAfter, "XPUSHs(sv_2mortal(result0));\n")
 	fg.numXsReturn++
+
+	buf := &bytes.Buffer{}
+	list := results
+	if n := len(list); n > 0 && isErrorType(list[n-1].typ) {
+		list = list[:n-1]
+		fmt.Fprintf(buf, "if goresult%d != nil {\n", n-1)
+		fmt.Fprintf(buf, "errSV = go2xsNewSVString(%q + goresult%d.Error())\n", fg.fd.Name.Name+": ", n-1)
+		fmt.Fprint(buf, "return\n}\n")
+	}
+	for i, r := range list {
+		if i == 0 && fg.isConstructor() {
+			fmt.Fprintf(buf, "if goresult%d == nil {\nresults = append(results, go2xsNewUndef())\n} else {\nresults = append(results, go2xsNewObject(class, goresult%d))\n}\n", i, i)
+			continue
+		}
+		conv, ok := fg.conv.toSV(r.typ)
+		if !ok {
+			fg.unsupported("result", exprs[i])
+			continue
+		}
+		fmt.Fprintf(buf, "results = append(results, %s(goresult%d))\n", conv, i)
+	}
+	for i := range results {
+		fg.goResults = append(fg.goResults, fmt.Sprintf("goresult%d", i))
+	}
+	fg.asyncResult = buf.String()
+}
+
+// addResultError croaks if the Go function returns an error.
+// The other results are returned only when the error is nil.
+func (fg *FuncGenerator) addResultError(index int) {
+	fmt.Fprintf(fg.goAfter, "if goresult%d != nil {\n", index)
+	fmt.Fprintf(fg.goAfter, "errSV = go2xsNewSVString(%q + goresult%d.Error())\n", fg.fd.Name.Name+": ", index)
+	fmt.Fprint(fg.goAfter, "return\n}\n")
+	fg.mayCroak = true
+}
+
+// variable is a parameter or a result of Go functions
+type variable struct {
+	name string
+	typ  types.Type
+}
+
+// tupleVars returns the variables of parameters or results.
+// Unnamed variables are named by the prefix and the index.
+func tupleVars(tuple *types.Tuple, prefix string) []variable {
+	vars := make([]variable, 0, tuple.Len())
+	for i := 0; i < tuple.Len(); i++ {
+		v := tuple.At(i)
+		name := v.Name()
+		if name == "" || name == "_" {
+			name = fmt.Sprintf("%s%d", prefix, i)
+		}
+		vars = append(vars, variable{name: name, typ: v.Type()})
+	}
+	return vars
+}
+
+// fieldExprs returns the type expressions of the fields.
+// The fields that declare multiple names are expanded, so the indexes match with tupleVars.
+func fieldExprs(list *ast.FieldList) []ast.Expr {
+	if list == nil {
+		return nil
+	}
+	var exprs []ast.Expr
+	for _, field := range list.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			exprs = append(exprs, field.Type)
+		}
+	}
+	return exprs
+}
+
+// isErrorType reports whether the type is the built-in error interface
+func isErrorType(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
+
+// isByteSlice reports whether the type is []byte or []uint8
+func isByteSlice(t types.Type) bool {
+	s, ok := types.Unalias(t).(*types.Slice)
+	if !ok {
+		return false
+	}
+	b, ok := types.Unalias(s.Elem()).(*types.Basic)
+	return ok && b.Kind() == types.Uint8
+}
+
+// isString reports whether the underlying type of t is string
+func isString(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsString != 0
 }
@@ -1,126 +1,586 @@
 package go2xs
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
+	"go/types"
 	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
 )
 
 type Generator struct {
+	// EmptyNilSlice converts nil slices into empty array references instead of undef
+	EmptyNilSlice bool
+
+	// Strict checks numbers strictly in all functions.
+	// It is same as the strict flag of go2xs directives.
+	Strict bool
+
+	// PanicStackTrace appends the stack trace of Go to the messages of panics
+	PanicStackTrace bool
+
+	// Metadata is the information of the Perl distribution
+	Metadata Metadata
+
+	// Naming is the naming convention of the functions that the go2xs directive doesn't name.
+	// The default is NamingAsIs.
+	Naming Naming
+
+	files          []string
 	funcGenerators []*FuncGenerator
+	conv           *converters
 }
 
 func NewGenerator() *Generator {
-	return &Generator{}
+	return &Generator{
+		conv: newConverters(),
+	}
 }
 
-func (g *Generator) ParseFile(path string) {
+// ParseFile adds the Go file of the package.
+// The files are type-checked together by Generate, so they can refer each other.
+// It returns scanner.ErrorList if the file has syntax errors.
+func (g *Generator) ParseFile(path string) error {
 	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	if _, err := parser.ParseFile(fset, path, nil, parser.ParseComments); err != nil {
+		return err
+	}
+	g.files = append(g.files, path)
+	return nil
+}
+
+// load type-checks the files, and creates FuncGenerators for the functions with go2xs directives.
+func (g *Generator) load() error {
+	if len(g.files) == 0 {
+		return nil
+	}
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo,
+	}
+	pkgs, err := packages.Load(cfg, g.files...)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
+	}
+	if errs := packageErrors(pkgs); len(errs) > 0 {
+		return errs
 	}
 
-	for _, d := range f.Decls {
-		if fd, ok := d.(*ast.FuncDecl); ok {
-			fg := NewFuncGenerator(fd)
-			if fg != nil {
-				g.funcGenerators = append(g.funcGenerators, fg)
+	for _, pkg := range pkgs {
+		g.conv.pkg = pkg.Types
+		for _, f := range pkg.Syntax {
+			for _, d := range f.Decls {
+				fd, ok := d.(*ast.FuncDecl)
+				if !ok {
+					continue
+				}
+				fn, ok := pkg.TypesInfo.Defs[fd.Name].(*types.Func)
+				if !ok {
+					continue
+				}
+				if fg := NewFuncGenerator(pkg.Fset, fd, fn); fg != nil {
+					g.funcGenerators = append(g.funcGenerators, fg)
+				}
 			}
 		}
 	}
+	return nil
+}
+
+// packageErrors collects the errors of the packages and their dependencies.
+func packageErrors(pkgs []*packages.Package) scanner.ErrorList {
+	var errs scanner.ErrorList
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, e := range pkg.Errors {
+			errs.Add(parsePosition(e.Pos), e.Msg)
+		}
+	})
+	errs.Sort()
+	errs.RemoveMultiples()
+	return errs
+}
+
+// parsePosition parses the position of packages.Error, e.g. "file:line:column".
+func parsePosition(s string) token.Position {
+	var nums []int
+	for len(nums) < 2 {
+		i := strings.LastIndex(s, ":")
+		if i < 0 {
+			break
+		}
+		n, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			break
+		}
+		nums = append(nums, n)
+		s = s[:i]
+	}
+
+	var pos token.Position
+	if s != "-" {
+		pos.Filename = s
+	}
+	switch len(nums) {
+	case 1:
+		pos.Line = nums[0]
+	case 2:
+		pos.Line, pos.Column = nums[1], nums[0]
+	}
+	return pos
 }
 
-func (g *Generator) Generate() {
+// Generate type-checks the files, and generates the glue code of the functions.
+// It returns scanner.ErrorList if the files have errors, or some functions have types that cannot be converted.
+func (g *Generator) Generate() error {
+	if err := g.Naming.validate(); err != nil {
+		return err
+	}
+	if err := g.load(); err != nil {
+		return err
+	}
+	g.conv.emptyNilSlice = g.EmptyNilSlice
+	for _, fg := range g.funcGenerators {
+		if fg.class != "" {
+			g.conv.classes[fg.class] = true
+		}
+	}
+	var errs scanner.ErrorList
 	for _, fg := range g.funcGenerators {
+		if fg.directive.name == "" {
+			fg.xsName = g.Naming.perlName(fg.fd.Name.Name)
+		}
+		fg.conv = g.conv
+		fg.strict = fg.strict || g.Strict
 		fg.Generate()
+		errs = append(errs, fg.errs...)
+	}
+	// the same names, or the names that the library names collide, make the same glue code
+	errs = append(errs, duplicates(g.funcGenerators, "", (*FuncGenerator).goGlueName)...)
+	errs.Sort()
+	return errs.Err()
+}
+
+// duplicates reports the functions whose keys are same as the former functions.
+func duplicates(fgs []*FuncGenerator, module string, key func(*FuncGenerator) string) scanner.ErrorList {
+	var errs scanner.ErrorList
+	seen := map[string]*FuncGenerator{}
+	for _, fg := range fgs {
+		k := key(fg)
+		prev, ok := seen[k]
+		if !ok {
+			seen[k] = fg
+			continue
+		}
+		errs.Add(fg.position(), fmt.Sprintf("%s: Perl function %s conflicts with %s defined at %s",
+			fg.fd.Name.Name, fg.perlName(module), prev.perlName(module), prev.position()))
+	}
+	return errs
+}
+
+// Output writes the generated files into the current directory.
+func (g *Generator) Output(name string) error {
+	return g.OutputTo(DirSink("."), name)
+}
+
+// OutputTo writes the generated files into the sink, and the manifest of them.
+// The Go files are copied too, unless the sink is the directory of them, so that Makefile.PL can build the package.
+// If the sink is CleanSink, the files of the previous generation that are no longer generated are removed.
+func (g *Generator) OutputTo(sink Sink, name string) error {
+	rec := &recordingSink{Sink: sink}
+	if err := g.output(rec, name); err != nil {
+		return err
+	}
+	if err := g.copySources(rec, sink); err != nil {
+		return err
+	}
+	return updateManifest(sink, rec.names)
+}
+
+// copySources writes the parsed Go files into rec, if they are not in the directory of sink.
+func (g *Generator) copySources(rec *recordingSink, sink Sink) error {
+	generated := map[string]bool{}
+	for _, name := range rec.names {
+		generated[name] = true
+	}
+	for _, file := range g.files {
+		if dir, ok := sink.(DirSink); ok && sameDir(filepath.Dir(file), string(dir)) {
+			continue
+		}
+		if generated[filepath.Base(file)] {
+			return fmt.Errorf("go2xs: %s conflicts with the generated file", file)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := rec.WriteFile(filepath.Base(file), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameDir reports whether a and b are the same directory.
+func sameDir(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fa, fb)
+}
+
+// exportTag is a tag of %EXPORT_TAGS in the Perl module.
+type exportTag struct {
+	Name  string
+	Funcs []string
+}
+
+// exports returns the functions of the module that can be exported, and their export tags.
+// The tags are given by the export option of the go2xs directive, or named after the Go source files.
+// The tag "all" has all functions.
+func (g *Generator) exports(name string) ([]string, []exportTag) {
+	var funcs []string
+	tagFuncs := map[string][]string{}
+	for _, fg := range g.funcGenerators {
+		if fg.class != "" || (fg.pkg != "" && fg.pkg != name) {
+			continue
+		}
+		funcs = append(funcs, fg.xsName)
+
+		tags := fg.directive.exports
+		if len(tags) == 0 && fg.fset != nil {
+			tags = []string{fileTag(fg.fset.Position(fg.fd.Pos()).Filename)}
+		}
+		for _, tag := range tags {
+			if tag != "all" {
+				tagFuncs[tag] = append(tagFuncs[tag], fg.xsName)
+			}
+		}
 	}
+	if len(funcs) == 0 {
+		return nil, nil
+	}
+
+	tags := []exportTag{{Name: "all", Funcs: funcs}}
+	names := make([]string, 0, len(tagFuncs))
+	for tag := range tagFuncs {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+	for _, tag := range names {
+		tags = append(tags, exportTag{Name: tag, Funcs: tagFuncs[tag]})
+	}
+	return funcs, tags
 }
 
-func (g *Generator) Output(name string) {
-	os.MkdirAll("lib", 0755)
-	ioutil.WriteFile("ppport.h", []byte(ppport), 0644)
-	ioutil.WriteFile("Makefile.PL", []byte(`use 5.010000;
-use ExtUtils::MakeMaker;
-
-my $ext;
-$ext = "dylib" if $^O eq 'darwin';
-$ext = "so" if $^O eq 'linux';
-system("go build -buildmode=c-shared -o lib`+name+`.$ext *.go") and die;
-
-# See lib/ExtUtils/MakeMaker.pm for details of how to influence
-# the contents of the Makefile that is written.
-WriteMakefile(
-    NAME              => '`+name+`',
-    VERSION_FROM      => 'lib/`+name+`.pm', # finds $VERSION
-    PREREQ_PM         => {}, # e.g., Module::Name => 1.1
-    ($] >= 5.005 ?     ## Add these new keywords supported since 5.005
-      (ABSTRACT_FROM  => 'lib/`+name+`.pm', # retrieve abstract from module
-       AUTHOR         => 'Ichinose Shogo <shogo@local>') : ()),
-    LIBS              => ['-L. -l`+name+`'], # e.g., '-lm'
-    DEFINE            => '', # e.g., '-DHAVE_SOMETHING'
-    INC               => '-I.', # e.g., '-I. -I/usr/include/other'
-# Un-comment this if you add C files to link with later:
-    # OBJECT            => '$(O_FILES)', # link all the C files too
-);
-`), 0644)
-	ioutil.WriteFile(path.Join("lib", name+".pm"), []byte(`package SomeModule;
-use 5.010000;
-use strict;
-use warnings;
-our $VERSION = '0.01';
-require XSLoader;
-XSLoader::load('`+name+`', $VERSION);
-1;
-__END__
-# Below is stub documentation for your module. You'd better edit it!
-=head1 NAME
-SomeModule - Perl extension for blah blah blah
-=head1 SYNOPSIS
-  use `+name+`;
-  blah blah blah
-=head1 DESCRIPTION
-Blah blah blah.
-=head2 EXPORT
-None by default.
-=head1 SEE ALSO
-=head1 AUTHOR
-Ichinose Shogo, E<lt>shogo@localE<gt>
-=head1 COPYRIGHT AND LICENSE
-=cut
-`), 0644)
-
-	xsFile, _ := os.OpenFile(name+".xs", os.O_WRONLY|os.O_CREATE, 0644)
-	defer xsFile.Close()
-	goFile, _ := os.OpenFile("go2xs.go", os.O_WRONLY|os.O_CREATE, 0644)
-	defer goFile.Close()
-
-	fmt.Fprintln(xsFile, `#define PERL_NO_GET_CONTEXT
+// fileTag returns the export tag named after the Go source file, e.g. "string_util" for "string-util.go".
+func fileTag(filename string) string {
+	base := strings.TrimSuffix(filepath.Base(filename), ".go")
+	tag := []byte(base)
+	for i, c := range tag {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_') {
+			tag[i] = '_'
+		}
+	}
+	if len(tag) == 0 || '0' <= tag[0] && tag[0] <= '9' {
+		return "_" + string(tag)
+	}
+	return string(tag)
+}
+
+func (g *Generator) output(sink Sink, name string) error {
+	if err := validatePackage(name); err != nil {
+		return err
+	}
+	// the functions qualified by the module name may collide with the others
+	perlName := func(fg *FuncGenerator) string { return fg.perlName(name) }
+	if errs := duplicates(g.funcGenerators, name, perlName); len(errs) > 0 {
+		return errs
+	}
+	if err := sink.WriteFile("ppport.h", []byte(ppport)); err != nil {
+		return err
+	}
+	if err := sink.WriteFile("go2xs_perl.go", []byte(perlRuntime)); err != nil {
+		return err
+	}
+	meta := g.Metadata.withDefaults()
+	if err := meta.validate(); err != nil {
+		return err
+	}
+	exports, tags := g.exports(name)
+	data := metadataData{
+		Metadata:   meta,
+		Name:       name,
+		ModuleFile: moduleFile(name),
+		LibName:    libraryName(name),
+		Exports:    exports,
+		ExportTags: tags,
+	}
+	makefile, err := executeTemplate(makefileTemplate, data)
+	if err != nil {
+		return err
+	}
+	if err := sink.WriteFile("Makefile.PL", makefile); err != nil {
+		return err
+	}
+	module, err := executeTemplate(moduleTemplate, data)
+	if err != nil {
+		return err
+	}
+	if err := sink.WriteFile(data.ModuleFile, module); err != nil {
+		return err
+	}
+
+	xsBuf := &bytes.Buffer{}
+	goBuf := &bytes.Buffer{}
+
+	fmt.Fprintln(xsBuf, `#define PERL_NO_GET_CONTEXT
 #include "EXTERN.h"
 #include "perl.h"
 #include "XSUB.h"
 #include "ppport.h"`)
-	fmt.Fprintf(xsFile, "#include \"lib%s.h\"\n", name)
-	fmt.Fprintf(xsFile, "MODULE = %s    PACKAGE = %s\n\n", name, name)
+	fmt.Fprintf(xsBuf, "#include \"lib%s.h\"\n", data.LibName)
+	fmt.Fprintf(xsBuf, "MODULE = %s    PACKAGE = %s\n\n", name, name)
+	fmt.Fprint(xsBuf, `BOOT:
+    go2xsBoot();
 
-	fmt.Fprint(goFile, `package main
+void
+go2xs_pump (...)
+    PPCODE:
+    if (items != 0)
+        croak_xs_usage(cv, "");
+{
+GoInt count;
+PUTBACK;
+count = go2xsPump();
+SPAGAIN;
+XSRETURN_IV(count);
+}
+
+void
+go2xs_pump_fd (...)
+    PPCODE:
+    if (items != 0)
+        croak_xs_usage(cv, "");
+{
+XSRETURN_IV(go2xsPumpFD());
+}
+
+`)
+
+	fmt.Fprint(goBuf, `package main
 
 import "C"
 
 import "unsafe"
-
+`)
+	fmt.Fprint(goBuf, g.conv.importDecls())
+	fmt.Fprint(goBuf, `
 var _ unsafe.Pointer
 
 func main() {}
+`)
+	fmt.Fprintf(goBuf, "\nconst go2xsModule = %q\n", name)
+	fmt.Fprintf(goBuf, "\nconst go2xsPanicStackTrace = %t\n\n", g.PanicStackTrace)
+	fmt.Fprint(goBuf, `//export go2xsBoot
+func go2xsBoot() {
+	go2xsInitThread()
+}
+
+//export go2xsPump
+func go2xsPump() int {
+	return go2xsPumpCalls()
+}
+
+//export go2xsPumpFD
+func go2xsPumpFD() int {
+	return go2xsDispatchFD()
+}
+
 `)
 
+	// functions of the module, functions placed in other packages, and methods of classes
+	pkgs := []string{name}
+	functions := map[string][]*FuncGenerator{}
+	var classes []string
+	var async bool
+	methods := map[string][]*FuncGenerator{}
 	for _, fg := range g.funcGenerators {
-		fmt.Fprintln(xsFile, fg.XSCode())
-		fmt.Fprintln(goFile, fg.GoCode())
+		async = async || fg.async
+		if fg.class == "" {
+			pkg := fg.pkg
+			if pkg == "" {
+				pkg = name
+			}
+			if _, ok := functions[pkg]; !ok && pkg != name {
+				pkgs = append(pkgs, pkg)
+			}
+			functions[pkg] = append(functions[pkg], fg)
+		} else {
+			if _, ok := methods[fg.class]; !ok {
+				classes = append(classes, fg.class)
+			}
+			methods[fg.class] = append(methods[fg.class], fg)
+		}
+		fmt.Fprintln(goBuf, fg.GoCode())
+	}
+	for i, pkg := range pkgs {
+		if i > 0 {
+			fmt.Fprintf(xsBuf, "MODULE = %s    PACKAGE = %s\n\n", name, pkg)
+		}
+		for _, fg := range functions[pkg] {
+			fmt.Fprintln(xsBuf, fg.XSCode())
+		}
+	}
+	for _, class := range classes {
+		fmt.Fprintf(xsBuf, "MODULE = %s    PACKAGE = %s::%s\n\n", name, name, class)
+		for _, fg := range methods[class] {
+			fmt.Fprintln(xsBuf, fg.XSCode())
+		}
+		// the handles are released by DESTROY, and never shared with other threads
+		fmt.Fprint(xsBuf, `void
+DESTROY (...)
+    PPCODE:
+{
+go2xsDestroy(ST(0));
+XSRETURN(0);
+}
+
+void
+CLONE_SKIP (...)
+    PPCODE:
+XSRETURN_IV(1);
+
+`)
+	}
+	if async {
+		// handles of async functions
+		fmt.Fprintf(xsBuf, "MODULE = %s    PACKAGE = %s::Async\n\n", name, name)
+		fmt.Fprint(xsBuf, `void
+result (...)
+    PPCODE:
+    if (items != 1)
+        croak_xs_usage(cv, "self");
+{
+SSize_t i, n;
+AV* av;
+struct go2xsAsync_result_return result;
+PUTBACK;
+result = go2xsAsync_result(ST(0));
+SPAGAIN;
+if (result.r1) croak("%" SVf, SVfARG(sv_2mortal(result.r1)));
+av = (AV*)SvRV(sv_2mortal(result.r0));
+n = av_len(av) + 1;
+EXTEND(SP, n);
+for (i = 0; i < n; i++) {
+    PUSHs(*av_fetch(av, i, 0));
+}
+XSRETURN(n);
+}
+
+void
+ready (...)
+    PPCODE:
+    if (items != 1)
+        croak_xs_usage(cv, "self");
+{
+struct go2xsAsync_ready_return result = go2xsAsync_ready(ST(0));
+if (result.r1) croak("%" SVf, SVfARG(sv_2mortal(result.r1)));
+XPUSHs(result.r0 ? &PL_sv_yes : &PL_sv_no);
+XSRETURN(1);
+}
+
+void
+fd (...)
+    PPCODE:
+    if (items != 1)
+        croak_xs_usage(cv, "self");
+{
+struct go2xsAsync_fd_return result = go2xsAsync_fd(ST(0));
+if (result.r1) croak("%" SVf, SVfARG(sv_2mortal(result.r1)));
+XSRETURN_IV(result.r0);
+}
+
+void
+DESTROY (...)
+    PPCODE:
+{
+go2xsDestroy(ST(0));
+XSRETURN(0);
+}
+
+void
+CLONE_SKIP (...)
+    PPCODE:
+XSRETURN_IV(1);
+
+`)
+		fmt.Fprint(goBuf, `//export go2xsAsync_result
+func go2xsAsync_result(sv unsafe.Pointer) (results unsafe.Pointer, errSV unsafe.Pointer) {
+	job, err := go2xsJobValue(sv)
+	if err != nil {
+		return nil, go2xsNewError(err)
+	}
+	svs, errSV := job.Result()
+	if errSV != nil {
+		return nil, errSV
+	}
+	results = go2xsNewAVRef(len(svs))
+	for _, sv := range svs {
+		go2xsAVPush(results, sv)
+	}
+	return results, nil
+}
+
+//export go2xsAsync_ready
+func go2xsAsync_ready(sv unsafe.Pointer) (ready bool, errSV unsafe.Pointer) {
+	job, err := go2xsJobValue(sv)
+	if err != nil {
+		return false, go2xsNewError(err)
+	}
+	return job.Ready(), nil
+}
+
+//export go2xsAsync_fd
+func go2xsAsync_fd(sv unsafe.Pointer) (fd int, errSV unsafe.Pointer) {
+	job, err := go2xsJobValue(sv)
+	if err != nil {
+		return -1, go2xsNewError(err)
+	}
+	fd, err = job.FD()
+	if err != nil {
+		return -1, go2xsNewError(err)
+	}
+	return fd, nil
+}
+
+`)
+	}
+	if len(classes) > 0 || async {
+		fmt.Fprint(goBuf, `//export go2xsDestroy
+func go2xsDestroy(sv unsafe.Pointer) {
+	go2xsDestroyObject(sv)
+}
+
+`)
+	}
+	fmt.Fprint(goBuf, g.conv.code())
+
+	if err := sink.WriteFile(baseName(name)+".xs", xsBuf.Bytes()); err != nil {
+		return err
 	}
+	return sink.WriteFile("go2xs.go", goBuf.Bytes())
 }
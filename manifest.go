@@ -0,0 +1,65 @@
+package go2xs
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// manifestName is the file that lists the generated files.
+// It is used for removing the files that are no longer generated.
+const manifestName = "go2xs.manifest"
+
+// recordingSink records the names of the written files.
+type recordingSink struct {
+	Sink
+	names []string
+}
+
+func (s *recordingSink) WriteFile(name string, data []byte) error {
+	if err := s.Sink.WriteFile(name, data); err != nil {
+		return err
+	}
+	s.names = append(s.names, name)
+	return nil
+}
+
+// updateManifest removes the files of the previous generation that are not in names,
+// and writes the new manifest.
+func updateManifest(sink Sink, names []string) error {
+	if cs, ok := sink.(CleanSink); ok {
+		if err := removeStaleFiles(cs, names); err != nil {
+			return err
+		}
+	}
+
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	return sink.WriteFile(manifestName, []byte(strings.Join(sorted, "\n")+"\n"))
+}
+
+func removeStaleFiles(sink CleanSink, names []string) error {
+	data, err := sink.ReadFile(manifestName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]bool, len(names))
+	for _, name := range names {
+		current[name] = true
+	}
+	for _, name := range strings.Split(string(data), "\n") {
+		// never remove files outside of the output directory, even if the manifest is broken.
+		if name == "" || name == manifestName || current[name] || !fs.ValidPath(name) {
+			continue
+		}
+		if err := sink.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
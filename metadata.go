@@ -0,0 +1,213 @@
+package go2xs
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Metadata is the information of the Perl distribution.
+// It is used in Makefile.PL, the Perl module, and META files generated by ExtUtils::MakeMaker.
+type Metadata struct {
+	// Version is the version of the module. The default is "0.01".
+	Version string `json:"version"`
+
+	// Abstract is the one line description of the module.
+	Abstract string `json:"abstract"`
+
+	// Author is the name and the email of the author, e.g. "John Doe <john@example.com>".
+	Author string `json:"author"`
+
+	// License is the license in the format of CPAN::Meta, e.g. "perl_5", "mit".
+	// The default is "unknown".
+	License string `json:"license"`
+
+	// MinPerlVersion is the minimum version of Perl. The default is "5.010000".
+	MinPerlVersion string `json:"min_perl_version"`
+}
+
+var versionPattern = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)*(_[0-9]+)?$`)
+
+// withDefaults returns the metadata that the default values are filled.
+func (m Metadata) withDefaults() Metadata {
+	if m.Version == "" {
+		m.Version = "0.01"
+	}
+	if m.Abstract == "" {
+		m.Abstract = "Perl extension of Go functions"
+	}
+	if m.License == "" {
+		m.License = "unknown"
+	}
+	if m.MinPerlVersion == "" {
+		m.MinPerlVersion = "5.010000"
+	}
+	return m
+}
+
+func (m Metadata) validate() error {
+	if !versionPattern.MatchString(m.Version) {
+		return fmt.Errorf("go2xs: invalid version %q", m.Version)
+	}
+	if !versionPattern.MatchString(m.MinPerlVersion) {
+		return fmt.Errorf("go2xs: invalid minimum Perl version %q", m.MinPerlVersion)
+	}
+	if strings.ContainsAny(m.Abstract+m.Author+m.License, "\r\n") {
+		return fmt.Errorf("go2xs: metadata must not contain newlines")
+	}
+	return nil
+}
+
+var (
+	identPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	packagePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(::[A-Za-z_][A-Za-z0-9_]*)*$`)
+)
+
+func validatePackage(name string) error {
+	if !packagePattern.MatchString(name) {
+		return fmt.Errorf("go2xs: invalid package name %q", name)
+	}
+	return nil
+}
+
+// moduleFile returns the path of the Perl module, e.g. "lib/Acme/Foo.pm" for Acme::Foo.
+func moduleFile(name string) string {
+	return path.Join("lib", path.Join(strings.Split(name, "::")...)+".pm")
+}
+
+// baseName returns the last component of the package name, e.g. "Foo" for Acme::Foo.
+// ExtUtils::MakeMaker looks for the XS file named after it.
+func baseName(name string) string {
+	return name[strings.LastIndex(name, ":")+1:]
+}
+
+// libraryName returns the name of the shared library built from Go code, e.g. "Acme_Foo" for Acme::Foo.
+func libraryName(name string) string {
+	return strings.Replace(name, "::", "_", -1)
+}
+
+var metadataFuncs = template.FuncMap{
+	// perl quotes the string for Perl
+	"perl": func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+	},
+	"join": strings.Join,
+	// pod escapes the string for POD
+	"pod": func(s string) string {
+		return strings.NewReplacer("<", "E<lt>", ">", "E<gt>").Replace(s)
+	},
+}
+
+var makefileTemplate = template.Must(template.New("Makefile.PL").Funcs(metadataFuncs).Parse(`use {{.MinPerlVersion}};
+use ExtUtils::MakeMaker;
+
+use ExtUtils::Embed;
+
+my $ext;
+$ext = "dylib" if $^O eq 'darwin';
+$ext = "so" if $^O eq 'linux';
+
+# the Go code calls the Perl API, which is resolved when the library is loaded
+$ENV{CGO_CFLAGS} = ccopts;
+$ENV{CGO_LDFLAGS} = '-Wl,-undefined,dynamic_lookup' if $^O eq 'darwin';
+system("go build -buildmode=c-shared -o lib{{.LibName}}.$ext *.go") and die;
+
+# See lib/ExtUtils/MakeMaker.pm for details of how to influence
+# the contents of the Makefile that is written.
+WriteMakefile(
+    NAME              => {{perl .Name}},
+    VERSION_FROM      => {{perl .ModuleFile}}, # finds $VERSION
+    ABSTRACT          => {{perl .Abstract}},
+{{- if .Author}}
+    AUTHOR            => [{{perl .Author}}],
+{{- end}}
+    LICENSE           => {{perl .License}},
+    MIN_PERL_VERSION  => {{perl .MinPerlVersion}},
+    PREREQ_PM         => {}, # e.g., Module::Name => 1.1
+    LIBS              => ['-L. -l{{.LibName}}'], # e.g., '-lm'
+    DEFINE            => '', # e.g., '-DHAVE_SOMETHING'
+    INC               => '-I.', # e.g., '-I. -I/usr/include/other'
+);
+`))
+
+var moduleTemplate = template.Must(template.New("module").Funcs(metadataFuncs).Parse(`package {{.Name}};
+use {{.MinPerlVersion}};
+use strict;
+use warnings;
+our $VERSION = {{perl .Version}};
+{{- if .Exports}}
+
+use Exporter 'import';
+our @EXPORT_OK = qw(
+{{- range .Exports}}
+    {{.}}
+{{- end}}
+);
+our %EXPORT_TAGS = (
+{{- range .ExportTags}}
+    {{.Name}} => [qw({{join .Funcs " "}})],
+{{- end}}
+);
+{{- end}}
+
+require XSLoader;
+XSLoader::load({{perl .Name}}, $VERSION);
+1;
+__END__
+
+=head1 NAME
+
+{{.Name}} - {{pod .Abstract}}
+
+=head1 SYNOPSIS
+
+  use {{.Name}}{{if .Exports}} qw(:all){{end}};
+
+=head1 DESCRIPTION
+
+{{.Name}} is generated by go2xs from Go functions.
+
+=head1 EXPORT
+
+{{if .Exports -}}
+Nothing is exported by default. The functions can be exported on request, or by the tags below.
+{{range .ExportTags}}
+  :{{.Name}} - {{join .Funcs " "}}
+{{- end}}
+{{- else -}}
+None.
+{{- end}}
+{{- if .Author}}
+
+=head1 AUTHOR
+
+{{pod .Author}}
+{{- end}}
+
+=head1 LICENSE
+
+{{pod .License}}
+
+=cut
+`))
+
+// metadataData is the data of the templates.
+type metadataData struct {
+	Metadata
+	Name       string
+	ModuleFile string
+	LibName    string
+	Exports    []string
+	ExportTags []exportTag
+}
+
+func executeTemplate(t *template.Template, data interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,53 @@
+package go2xs
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Naming is the naming convention of the Perl functions named after Go functions,
+// i.e. the functions with the go2xs directive that doesn't give the name.
+type Naming string
+
+const (
+	// NamingAsIs uses the Go names as is, e.g. "ParseJSON" for ParseJSON.
+	NamingAsIs Naming = "as-is"
+
+	// NamingSnakeCase converts CamelCase Go names into snake_case, e.g. "parse_json" for ParseJSON.
+	NamingSnakeCase Naming = "snake_case"
+)
+
+func (n Naming) validate() error {
+	switch n {
+	case "", NamingAsIs, NamingSnakeCase:
+		return nil
+	}
+	return fmt.Errorf("go2xs: unknown naming convention %q", string(n))
+}
+
+// perlName returns the Perl name of the Go function.
+func (n Naming) perlName(name string) string {
+	if n == NamingSnakeCase {
+		return snakeCase(name)
+	}
+	return name
+}
+
+// snakeCase converts CamelCase into snake_case.
+// Acronyms are kept together, e.g. "HTTPServer" into "http_server".
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && runes[i-1] != '_' {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
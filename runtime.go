@@ -0,0 +1,898 @@
+package go2xs
+
+// perlRuntime is the helper library for accessing Perl values from Go.
+// It is written into go2xs_perl.go, and the converters in go2xs.go use it.
+// Its functions must be called on the Perl thread, except go2xsRunOnPerlThread.
+var perlRuntime = `package main
+
+/*
+#include "EXTERN.h"
+#include "perl.h"
+#include <pthread.h>
+
+static pthread_t go2xs_perl_thread;
+static void go2xs_init_thread() { go2xs_perl_thread = pthread_self(); }
+static int go2xs_is_perl_thread() { return pthread_equal(pthread_self(), go2xs_perl_thread) ? 1 : 0; }
+
+static IV go2xs_sv_iv(SV* sv) { dTHX; return SvIV(sv); }
+static UV go2xs_sv_uv(SV* sv) { dTHX; return SvUV(sv); }
+static NV go2xs_sv_nv(SV* sv) { dTHX; return SvNV(sv); }
+static int go2xs_sv_true(SV* sv) { dTHX; return SvTRUE(sv) ? 1 : 0; }
+static int go2xs_sv_ok(SV* sv) { dTHX; SvGETMAGIC(sv); return SvOK(sv) ? 1 : 0; }
+static const char* go2xs_sv_pv(SV* sv, STRLEN* len) { dTHX; return SvPV(sv, *len); }
+static const char* go2xs_sv_pvbyte(SV* sv, STRLEN* len) { dTHX; return SvPVbyte(sv, *len); }
+
+// go2xs_sv_check_* convert SV into numbers strictly.
+// They return 0 on success, 1 if the SV is not a number, and 2 if the number is out of range.
+// Floating point numbers are compared with 2^63 and 2^64, because (NV)IV_MAX and (NV)UV_MAX round up to them,
+// and SvIV/SvUV wrap or clamp the numbers out of range.
+static int go2xs_sv_check_iv(SV* sv, IV min, IV max, IV* out) {
+    dTHX;
+    int is_nv;
+    SvGETMAGIC(sv);
+    if (!SvOK(sv) || !looks_like_number(sv)) return 1;
+    is_nv = SvNOK(sv) && !SvIOK(sv);
+    (void)SvIV_nomg(sv);
+    if (!is_nv && SvIOK(sv)) {
+        // exact integers
+        if (SvIsUV(sv)) {
+            if (SvUVX(sv) > (UV)IV_MAX) return 2;
+            *out = (IV)SvUVX(sv);
+        } else {
+            *out = SvIVX(sv);
+        }
+    } else {
+        NV nv = SvNV_nomg(sv);
+        // NaN passes the comparisons
+        // -2^63 as NV may be rounded from the smaller numbers, e.g. -2**63-1.
+        if (Perl_isnan(nv) || nv <= -9223372036854775808.0 || nv >= 9223372036854775808.0) return 2;
+        *out = (IV)nv;
+    }
+    if (*out < min || *out > max) return 2;
+    return 0;
+}
+static int go2xs_sv_check_uv(SV* sv, UV max, UV* out) {
+    dTHX;
+    int is_nv;
+    SvGETMAGIC(sv);
+    if (!SvOK(sv) || !looks_like_number(sv)) return 1;
+    is_nv = SvNOK(sv) && !SvIOK(sv);
+    (void)SvIV_nomg(sv);
+    if (!is_nv && SvIOK(sv)) {
+        // exact integers
+        if (SvIsUV(sv)) {
+            *out = SvUVX(sv);
+        } else {
+            if (SvIVX(sv) < 0) return 2;
+            *out = (UV)SvIVX(sv);
+        }
+    } else {
+        NV nv = SvNV_nomg(sv);
+        if (Perl_isnan(nv) || nv < 0 || nv >= 18446744073709551616.0) return 2;
+        *out = (UV)nv;
+    }
+    if (*out > max) return 2;
+    return 0;
+}
+static int go2xs_sv_check_nv(SV* sv, NV* out) {
+    dTHX;
+    SvGETMAGIC(sv);
+    if (!SvOK(sv) || !looks_like_number(sv)) return 1;
+    *out = SvNV_nomg(sv);
+    return 0;
+}
+
+static SV* go2xs_new_sv_iv(IV v) { dTHX; return newSViv(v); }
+static SV* go2xs_new_sv_uv(UV v) { dTHX; return newSVuv(v); }
+static SV* go2xs_new_sv_nv(NV v) { dTHX; return newSVnv(v); }
+static SV* go2xs_new_sv_bool(int v) { dTHX; return newSVsv(v ? &PL_sv_yes : &PL_sv_no); }
+static SV* go2xs_new_sv_string(_GoString_ s) { dTHX; return newSVpvn(_GoStringPtr(s), _GoStringLen(s)); }
+static SV* go2xs_new_sv_bytes(const char* p, STRLEN len) { dTHX; return newSVpvn(len ? p : "", len); }
+static SV* go2xs_new_undef() { dTHX; return newSV(0); }
+
+static int go2xs_is_arrayref(SV* sv) {
+    dTHX;
+    SvGETMAGIC(sv);
+    return SvROK(sv) && SvTYPE(SvRV(sv)) == SVt_PVAV;
+}
+static SSize_t go2xs_av_len(SV* rv) { dTHX; return av_len((AV*)SvRV(rv)) + 1; }
+static SV* go2xs_av_fetch(SV* rv, SSize_t i) {
+    dTHX;
+    SV** svp = av_fetch((AV*)SvRV(rv), i, 0);
+    return svp ? *svp : &PL_sv_undef;
+}
+static SV* go2xs_new_av_ref(SSize_t n) {
+    dTHX;
+    AV* av = newAV();
+    if (n > 0) av_extend(av, n - 1);
+    return newRV_noinc((SV*)av);
+}
+static void go2xs_av_push(SV* rv, SV* sv) { dTHX; av_push((AV*)SvRV(rv), sv); }
+
+static int go2xs_is_hashref(SV* sv) {
+    dTHX;
+    SvGETMAGIC(sv);
+    return SvROK(sv) && SvTYPE(SvRV(sv)) == SVt_PVHV;
+}
+static I32 go2xs_hv_iterinit(SV* rv) { dTHX; return hv_iterinit((HV*)SvRV(rv)); }
+static SV* go2xs_hv_iternext(SV* rv, char** key, I32* klen) {
+    dTHX;
+    HV* hv = (HV*)SvRV(rv);
+    HE* he = hv_iternext(hv);
+    if (!he) return NULL;
+    *key = hv_iterkey(he, klen);
+    return hv_iterval(hv, he);
+}
+static SV* go2xs_new_hv_ref() { dTHX; return newRV_noinc((SV*)newHV()); }
+static SV* go2xs_hv_fetch(SV* rv, _GoString_ key) {
+    dTHX;
+    SV** svp = hv_fetch((HV*)SvRV(rv), _GoStringPtr(key), _GoStringLen(key), 0);
+    return svp ? *svp : NULL;
+}
+static void go2xs_hv_store(SV* rv, _GoString_ key, SV* sv) {
+    dTHX;
+    hv_store((HV*)SvRV(rv), _GoStringPtr(key), _GoStringLen(key), sv, 0);
+}
+
+static int go2xs_is_coderef(SV* sv) {
+    dTHX;
+    SvGETMAGIC(sv);
+    return SvROK(sv) && SvTYPE(SvRV(sv)) == SVt_PVCV;
+}
+static SV* go2xs_new_sv_copy(SV* sv) { dTHX; return newSVsv(sv); }
+static void go2xs_free_sv(SV* sv) { dTHX; SvREFCNT_dec(sv); }
+
+// go2xs_call_sv calls the code with args, and stores new SVs of the results.
+// The args are mortalized. *err is set if the code dies.
+static void go2xs_call_sv(SV* code, SV** args, int nargs, SV** results, int nresults, SV** err) {
+    dTHX;
+    dSP;
+    int count, i, flags;
+
+    ENTER;
+    SAVETMPS;
+    PUSHMARK(SP);
+    EXTEND(SP, nargs);
+    for (i = 0; i < nargs; i++) {
+        PUSHs(sv_2mortal(args[i]));
+    }
+    PUTBACK;
+
+    flags = nresults == 0 ? G_VOID : nresults == 1 ? G_SCALAR : G_ARRAY;
+    count = call_sv(code, flags | G_EVAL);
+
+    SPAGAIN;
+    if (SvTRUE(ERRSV)) {
+        *err = newSVsv(ERRSV);
+    } else {
+        for (i = 0; i < nresults; i++) {
+            results[i] = newSVsv(i < count ? *(SP - count + 1 + i) : &PL_sv_undef);
+        }
+    }
+    SP -= count;
+    PUTBACK;
+    FREETMPS;
+    LEAVE;
+}
+
+static SV* go2xs_new_object(_GoString_ class, UV h, SV** obj) {
+    dTHX;
+    HV* stash = gv_stashpvn(_GoStringPtr(class), _GoStringLen(class), GV_ADD);
+    SV* sv = newSVuv(h);
+    SV* rv = sv_bless(newRV_noinc(sv), stash);
+    SvREADONLY_on(sv);
+    *obj = sv;
+    return rv;
+}
+// go2xs_class_name returns the class name of the constructor, or NULL if the class is not derived from base.
+// The class of the object is used, if the constructor is called via the object.
+static const char* go2xs_class_name(SV* sv, _GoString_ base, STRLEN* len) {
+    dTHX;
+    const char* name;
+    SvGETMAGIC(sv);
+    if (sv_isobject(sv)) {
+        name = sv_reftype(SvRV(sv), TRUE);
+        *len = strlen(name);
+    } else if (SvOK(sv) && !SvROK(sv)) {
+        name = SvPV_nomg(sv, *len);
+    } else {
+        return NULL;
+    }
+    if (!sv_derived_from_pvn(sv, _GoStringPtr(base), _GoStringLen(base), 0)) return NULL;
+    return name;
+}
+// go2xs_object_handle returns the handle of the object, and the SV which holds the handle.
+// If class is not empty, the object must be derived from the class.
+static UV go2xs_object_handle(SV* sv, _GoString_ class, SV** obj) {
+    dTHX;
+    SvGETMAGIC(sv);
+    if (!sv_isobject(sv)) return 0;
+    if (_GoStringLen(class) > 0 && !sv_derived_from_pvn(sv, _GoStringPtr(class), _GoStringLen(class), 0)) return 0;
+    *obj = SvRV(sv);
+    return SvUV(*obj);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/cgo"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+func go2xsSVIV(sv unsafe.Pointer) int64 {
+	return int64(C.go2xs_sv_iv((*C.SV)(sv)))
+}
+
+func go2xsSVUV(sv unsafe.Pointer) uint64 {
+	return uint64(C.go2xs_sv_uv((*C.SV)(sv)))
+}
+
+func go2xsSVNV(sv unsafe.Pointer) float64 {
+	return float64(C.go2xs_sv_nv((*C.SV)(sv)))
+}
+
+func go2xsSVTrue(sv unsafe.Pointer) bool {
+	return C.go2xs_sv_true((*C.SV)(sv)) != 0
+}
+
+func go2xsSVString(sv unsafe.Pointer) string {
+	var l C.STRLEN
+	p := C.go2xs_sv_pv((*C.SV)(sv), &l)
+	return C.GoStringN(p, C.int(l))
+}
+
+func go2xsSVOK(sv unsafe.Pointer) bool {
+	return C.go2xs_sv_ok((*C.SV)(sv)) != 0
+}
+
+func go2xsSVBytes(sv unsafe.Pointer) []byte {
+	var l C.STRLEN
+	p := C.go2xs_sv_pvbyte((*C.SV)(sv), &l)
+	return C.GoBytes(unsafe.Pointer(p), C.int(l))
+}
+
+const (
+	go2xsMaxInt  = int64(^uint(0) >> 1)
+	go2xsMinInt  = -go2xsMaxInt - 1
+	go2xsMaxUint = uint64(^uint(0))
+
+	// same as math.MaxFloat32 and math.MaxFloat64
+	go2xsMaxFloat32 = 0x1p127 * (1 + (1 - 0x1p-23))
+	go2xsMaxFloat64 = 0x1p1023 * (1 + (1 - 0x1p-52))
+)
+
+// go2xsSVIVStrict converts SV into an integer in [min, max].
+func go2xsSVIVStrict(sv unsafe.Pointer, typ string, min, max int64) (int64, error) {
+	var v C.IV
+	switch C.go2xs_sv_check_iv((*C.SV)(sv), C.IV(min), C.IV(max), &v) {
+	case 1:
+		return 0, go2xsNotNumberError(sv)
+	case 2:
+		return 0, go2xsRangeError(sv, typ)
+	}
+	return int64(v), nil
+}
+
+// go2xsSVUVStrict converts SV into an unsigned integer in [0, max].
+func go2xsSVUVStrict(sv unsafe.Pointer, typ string, max uint64) (uint64, error) {
+	var v C.UV
+	switch C.go2xs_sv_check_uv((*C.SV)(sv), C.UV(max), &v) {
+	case 1:
+		return 0, go2xsNotNumberError(sv)
+	case 2:
+		return 0, go2xsRangeError(sv, typ)
+	}
+	return uint64(v), nil
+}
+
+// go2xsSVNVStrict converts SV into a floating point number in [-max, max], or an infinity.
+func go2xsSVNVStrict(sv unsafe.Pointer, typ string, max float64) (float64, error) {
+	var v C.NV
+	if C.go2xs_sv_check_nv((*C.SV)(sv), &v) != 0 {
+		return 0, go2xsNotNumberError(sv)
+	}
+	f := float64(v)
+	// infinities are allowed, but finite numbers must not overflow
+	if (f > max && f <= go2xsMaxFloat64) || (f < -max && f >= -go2xsMaxFloat64) {
+		return 0, go2xsRangeError(sv, typ)
+	}
+	return f, nil
+}
+
+func go2xsNotNumberError(sv unsafe.Pointer) error {
+	if !go2xsSVOK(sv) {
+		return &go2xsPathError{msg: "undef is not a number"}
+	}
+	return &go2xsPathError{msg: strconv.Quote(go2xsSVString(sv)) + " is not a number"}
+}
+
+func go2xsRangeError(sv unsafe.Pointer, typ string) error {
+	return &go2xsPathError{msg: go2xsSVString(sv) + " is out of range for " + typ}
+}
+
+func go2xsNewSVIV(v int64) unsafe.Pointer {
+	return unsafe.Pointer(C.go2xs_new_sv_iv(C.IV(v)))
+}
+
+func go2xsNewSVUV(v uint64) unsafe.Pointer {
+	return unsafe.Pointer(C.go2xs_new_sv_uv(C.UV(v)))
+}
+
+func go2xsNewSVNV(v float64) unsafe.Pointer {
+	return unsafe.Pointer(C.go2xs_new_sv_nv(C.NV(v)))
+}
+
+func go2xsNewSVBool(v bool) unsafe.Pointer {
+	if v {
+		return unsafe.Pointer(C.go2xs_new_sv_bool(1))
+	}
+	return unsafe.Pointer(C.go2xs_new_sv_bool(0))
+}
+
+func go2xsNewSVString(v string) unsafe.Pointer {
+	return unsafe.Pointer(C.go2xs_new_sv_string(v))
+}
+
+func go2xsNewSVBytes(v []byte) unsafe.Pointer {
+	if len(v) == 0 {
+		return unsafe.Pointer(C.go2xs_new_sv_bytes(nil, 0))
+	}
+	return unsafe.Pointer(C.go2xs_new_sv_bytes((*C.char)(unsafe.Pointer(&v[0])), C.STRLEN(len(v))))
+}
+
+func go2xsNewUndef() unsafe.Pointer {
+	return unsafe.Pointer(C.go2xs_new_undef())
+}
+
+func go2xsIsArrayRef(sv unsafe.Pointer) bool {
+	return C.go2xs_is_arrayref((*C.SV)(sv)) != 0
+}
+
+func go2xsAVLen(rv unsafe.Pointer) int {
+	return int(C.go2xs_av_len((*C.SV)(rv)))
+}
+
+func go2xsAVFetch(rv unsafe.Pointer, i int) unsafe.Pointer {
+	return unsafe.Pointer(C.go2xs_av_fetch((*C.SV)(rv), C.SSize_t(i)))
+}
+
+func go2xsNewAVRef(n int) unsafe.Pointer {
+	return unsafe.Pointer(C.go2xs_new_av_ref(C.SSize_t(n)))
+}
+
+// go2xsAVPush appends sv to the array. The array takes the ownership of sv.
+func go2xsAVPush(rv, sv unsafe.Pointer) {
+	C.go2xs_av_push((*C.SV)(rv), (*C.SV)(sv))
+}
+
+func go2xsIsHashRef(sv unsafe.Pointer) bool {
+	return C.go2xs_is_hashref((*C.SV)(sv)) != 0
+}
+
+// go2xsHVIterInit prepares to iterate the hash, and returns the number of the keys.
+func go2xsHVIterInit(rv unsafe.Pointer) int {
+	return int(C.go2xs_hv_iterinit((*C.SV)(rv)))
+}
+
+// go2xsHVIterNext returns the next entry of the hash. ok is false at the end of the hash.
+func go2xsHVIterNext(rv unsafe.Pointer) (key string, sv unsafe.Pointer, ok bool) {
+	var k *C.char
+	var l C.I32
+	val := C.go2xs_hv_iternext((*C.SV)(rv), &k, &l)
+	if val == nil {
+		return "", nil, false
+	}
+	return C.GoStringN(k, C.int(l)), unsafe.Pointer(val), true
+}
+
+func go2xsNewHVRef() unsafe.Pointer {
+	return unsafe.Pointer(C.go2xs_new_hv_ref())
+}
+
+// go2xsHVFetch returns the value of the key. It returns nil if the key doesn't exist.
+func go2xsHVFetch(rv unsafe.Pointer, key string) unsafe.Pointer {
+	return unsafe.Pointer(C.go2xs_hv_fetch((*C.SV)(rv), key))
+}
+
+// go2xsHVStore stores sv into the hash. The hash takes the ownership of sv.
+func go2xsHVStore(rv unsafe.Pointer, key string, sv unsafe.Pointer) {
+	C.go2xs_hv_store((*C.SV)(rv), key, (*C.SV)(sv))
+}
+
+func go2xsIsCodeRef(sv unsafe.Pointer) bool {
+	return C.go2xs_is_coderef((*C.SV)(sv)) != 0
+}
+
+// go2xsFreeSVs decrements the reference counts of SVs.
+func go2xsFreeSVs(svs []unsafe.Pointer) {
+	for _, sv := range svs {
+		C.go2xs_free_sv((*C.SV)(sv))
+	}
+}
+
+// go2xsCallback is a Perl code reference held by Go.
+type go2xsCallback struct {
+	sv unsafe.Pointer
+}
+
+// the callbacks collected by GC. their SVs are freed on the Perl thread later.
+var go2xsReleasedCallbacks struct {
+	sync.Mutex
+	svs []unsafe.Pointer
+}
+
+// go2xsNewCallback copies the code reference, and keeps it alive while Go refers it.
+func go2xsNewCallback(sv unsafe.Pointer) *go2xsCallback {
+	go2xsReleaseCallbacks()
+	cb := &go2xsCallback{
+		sv: unsafe.Pointer(C.go2xs_new_sv_copy((*C.SV)(sv))),
+	}
+	runtime.SetFinalizer(cb, func(cb *go2xsCallback) {
+		// finalizers don't run on the Perl thread, so we can't free SV here.
+		go2xsReleasedCallbacks.Lock()
+		go2xsReleasedCallbacks.svs = append(go2xsReleasedCallbacks.svs, cb.sv)
+		go2xsReleasedCallbacks.Unlock()
+	})
+	return cb
+}
+
+// go2xsReleaseCallbacks frees the callbacks collected by GC.
+func go2xsReleaseCallbacks() {
+	go2xsReleasedCallbacks.Lock()
+	svs := go2xsReleasedCallbacks.svs
+	go2xsReleasedCallbacks.svs = nil
+	go2xsReleasedCallbacks.Unlock()
+	go2xsFreeSVs(svs)
+}
+
+// Call calls the Perl code with args, and returns new SVs of the results.
+// It takes the ownership of args. The caller must free the results.
+// It returns *go2xsPerlError if the code dies.
+func (cb *go2xsCallback) Call(args []unsafe.Pointer, nresults int) ([]unsafe.Pointer, error) {
+	results := make([]unsafe.Pointer, nresults)
+	var argsPtr, resultsPtr **C.SV
+	if len(args) > 0 {
+		argsPtr = (**C.SV)(unsafe.Pointer(&args[0]))
+	}
+	if nresults > 0 {
+		resultsPtr = (**C.SV)(unsafe.Pointer(&results[0]))
+	}
+	var errSV *C.SV
+	C.go2xs_call_sv((*C.SV)(cb.sv), argsPtr, C.int(len(args)), resultsPtr, C.int(nresults), &errSV)
+	runtime.KeepAlive(cb)
+	if errSV != nil {
+		msg := go2xsSVString(unsafe.Pointer(errSV))
+		C.go2xs_free_sv(errSV)
+		return nil, &go2xsPerlError{msg: msg}
+	}
+	return results, nil
+}
+
+// go2xsPerlError is the exception thrown by Perl code.
+type go2xsPerlError struct {
+	msg string
+}
+
+func (e *go2xsPerlError) Error() string {
+	return strings.TrimSuffix(e.msg, "\n")
+}
+
+// go2xsDispatch is the queue of the calls from other threads to the Perl thread.
+var go2xsDispatch struct {
+	sync.Mutex
+	calls []*go2xsDispatchCall
+
+	// notify wakes up go2xsServe.
+	notify chan struct{}
+
+	// the pipe for waking up event loops. it becomes readable when a call is queued.
+	r, w int
+}
+
+type go2xsDispatchCall struct {
+	fn       func()
+	done     chan struct{}
+	panicked bool
+	value    interface{}
+}
+
+// go2xsInitThread records the current thread as the Perl thread.
+// It is called when the module is loaded.
+func go2xsInitThread() {
+	C.go2xs_init_thread()
+	go2xsDispatch.notify = make(chan struct{}, 1)
+	go2xsDispatch.r, go2xsDispatch.w, _ = go2xsPipe()
+}
+
+// go2xsPipe creates a non-blocking pipe. The file descriptors are -1 on error.
+func go2xsPipe() (r, w int, err error) {
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		return -1, -1, err
+	}
+	for _, fd := range fds {
+		syscall.CloseOnExec(fd)
+		syscall.SetNonblock(fd, true)
+	}
+	return fds[0], fds[1], nil
+}
+
+// go2xsDrain reads all bytes from the non-blocking file descriptor.
+func go2xsDrain(fd int) {
+	var buf [64]byte
+	for {
+		n, err := syscall.Read(fd, buf[:])
+		if n <= 0 || err != nil {
+			return
+		}
+	}
+}
+
+// go2xsRunOnPerlThread runs fn on the Perl thread.
+// If it is called on the Perl thread, fn runs immediately.
+// Otherwise it waits until the call is run by go2xsPumpCalls, and the panic of fn is propagated to the caller.
+func go2xsRunOnPerlThread(fn func()) {
+	if C.go2xs_is_perl_thread() != 0 {
+		fn()
+		return
+	}
+
+	call := &go2xsDispatchCall{
+		fn:   fn,
+		done: make(chan struct{}),
+	}
+	go2xsDispatch.Lock()
+	go2xsDispatch.calls = append(go2xsDispatch.calls, call)
+	go2xsDispatch.Unlock()
+	select {
+	case go2xsDispatch.notify <- struct{}{}:
+	default:
+	}
+	if go2xsDispatch.w >= 0 {
+		// EAGAIN is ignored, the pipe is already readable.
+		syscall.Write(go2xsDispatch.w, []byte{0})
+	}
+
+	<-call.done
+	if call.panicked {
+		panic(call.value)
+	}
+}
+
+func (call *go2xsDispatchCall) run() {
+	defer close(call.done)
+	defer func() {
+		if r := recover(); r != nil {
+			call.panicked = true
+			call.value = r
+		}
+	}()
+	call.fn()
+}
+
+// go2xsPumpCalls runs the queued calls on the Perl thread, and returns the number of them.
+func go2xsPumpCalls() int {
+	if go2xsDispatch.r >= 0 {
+		go2xsDrain(go2xsDispatch.r)
+	}
+	go2xsReleaseCallbacks()
+
+	count := 0
+	for {
+		go2xsDispatch.Lock()
+		calls := go2xsDispatch.calls
+		go2xsDispatch.calls = nil
+		go2xsDispatch.Unlock()
+		if len(calls) == 0 {
+			return count
+		}
+		for _, call := range calls {
+			call.run()
+			count++
+		}
+	}
+}
+
+// go2xsDispatchFD returns the file descriptor which becomes readable when calls are queued.
+// It returns -1 if the pipe is not available.
+func go2xsDispatchFD() int {
+	return go2xsDispatch.r
+}
+
+// go2xsServe runs fn in a new goroutine, and runs the queued calls until fn returns.
+// So fn can wait for goroutines that call callbacks.
+// The panic of fn is propagated to the caller.
+func go2xsServe(fn func()) {
+	done := make(chan struct{})
+	var panicked bool
+	var value interface{}
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				panicked = true
+				value = r
+				if go2xsPanicStackTrace {
+					value = &go2xsGoroutinePanic{value: r, stack: debug.Stack()}
+				}
+			}
+		}()
+		fn()
+	}()
+
+	go2xsWait(done)
+	if panicked {
+		panic(value)
+	}
+}
+
+// go2xsWait runs the queued calls until done is closed.
+func go2xsWait(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-go2xsDispatch.notify:
+			go2xsPumpCalls()
+		}
+	}
+}
+
+// go2xsGoroutinePanic is the panic in go2xsServe, with the stack trace of the goroutine.
+type go2xsGoroutinePanic struct {
+	value interface{}
+	stack []byte
+}
+
+// go2xsJob is the Go function running in background, started by async functions.
+type go2xsJob struct {
+	name         string
+	recoverPanic bool
+	done         chan struct{}
+
+	// result converts the results on the Perl thread.
+	result   func() ([]unsafe.Pointer, unsafe.Pointer)
+	panicked bool
+	value    interface{}
+
+	// the pipe for notifying the completion. it is created by FD.
+	mu       sync.Mutex
+	finished bool
+	r, w     int
+}
+
+// go2xsStartJob runs fn in a new goroutine.
+// fn calls the Go function, and returns the function that converts the results into SVs.
+// If recoverPanic is true, the panic of fn is croaked by Result.
+func go2xsStartJob(name string, recoverPanic bool, fn func() func() ([]unsafe.Pointer, unsafe.Pointer)) *go2xsJob {
+	job := &go2xsJob{
+		name:         name,
+		recoverPanic: recoverPanic,
+		done:         make(chan struct{}),
+		r:            -1,
+		w:            -1,
+	}
+	go job.run(fn)
+	return job
+}
+
+func (job *go2xsJob) run(fn func() func() ([]unsafe.Pointer, unsafe.Pointer)) {
+	defer job.finish()
+	if job.recoverPanic {
+		defer func() {
+			if r := recover(); r != nil {
+				job.panicked = true
+				job.value = r
+				if go2xsPanicStackTrace {
+					job.value = &go2xsGoroutinePanic{value: r, stack: debug.Stack()}
+				}
+			}
+		}()
+	}
+	job.result = fn()
+}
+
+func (job *go2xsJob) finish() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.finished = true
+	close(job.done)
+	if job.w >= 0 {
+		syscall.Write(job.w, []byte{0})
+	}
+}
+
+// Ready reports whether the job has finished.
+func (job *go2xsJob) Ready() bool {
+	select {
+	case <-job.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// FD returns the file descriptor which becomes readable when the job finishes.
+func (job *go2xsJob) FD() (int, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.r >= 0 {
+		return job.r, nil
+	}
+	r, w, err := go2xsPipe()
+	if err != nil {
+		return -1, err
+	}
+	job.r, job.w = r, w
+	if job.finished {
+		syscall.Write(job.w, []byte{0})
+	}
+	return job.r, nil
+}
+
+// Result waits for the job, and returns new SVs of the results.
+// It runs the queued calls while waiting, so the job can call callbacks.
+func (job *go2xsJob) Result() ([]unsafe.Pointer, unsafe.Pointer) {
+	go2xsWait(job.done)
+	if job.panicked {
+		return nil, go2xsPanicError(job.name, job.value)
+	}
+	return job.result()
+}
+
+// go2xsRelease closes the pipe. It is called when the handle is destroyed.
+func (job *go2xsJob) go2xsRelease() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.r >= 0 {
+		syscall.Close(job.r)
+		syscall.Close(job.w)
+		job.r, job.w = -1, -1
+	}
+}
+
+// go2xsJobValue returns the job of the handle.
+func go2xsJobValue(sv unsafe.Pointer) (*go2xsJob, error) {
+	job, _ := go2xsObjectValue(sv, go2xsModule+"::Async").(*go2xsJob)
+	if job == nil {
+		return nil, go2xsWrapPath(go2xsTypeError("a "+go2xsModule+"::Async object"), "self")
+	}
+	return job, nil
+}
+
+// go2xsObjects are the handles of the live objects, and the SVs which hold them.
+// The handles are valid only in the SVs created by go2xsNewObject,
+// and the copies, e.g. forged by bless or cloned by Storable::dclone, are ignored.
+var (
+	go2xsObjectsMu sync.Mutex
+	go2xsObjects   = map[cgo.Handle]unsafe.Pointer{}
+)
+
+// go2xsNewObject creates the object of the class, which refers the Go value.
+// The value is kept alive until the object is destroyed.
+func go2xsNewObject(class string, v interface{}) unsafe.Pointer {
+	h := cgo.NewHandle(v)
+	var obj *C.SV
+	rv := unsafe.Pointer(C.go2xs_new_object(class, C.UV(h), &obj))
+	go2xsObjectsMu.Lock()
+	go2xsObjects[h] = unsafe.Pointer(obj)
+	go2xsObjectsMu.Unlock()
+	return rv
+}
+
+// go2xsClassName returns the class name given to the constructor of the base class.
+func go2xsClassName(sv unsafe.Pointer, base string) (string, error) {
+	var l C.STRLEN
+	p := C.go2xs_class_name((*C.SV)(sv), base, &l)
+	if p == nil {
+		return "", go2xsTypeError("a subclass of " + base)
+	}
+	return C.GoStringN(p, C.int(l)), nil
+}
+
+// go2xsObjectHandle returns the handle of the object.
+// It returns false if sv is not a live object of the class.
+func go2xsObjectHandle(sv unsafe.Pointer, class string) (cgo.Handle, bool) {
+	var obj *C.SV
+	h := cgo.Handle(C.go2xs_object_handle((*C.SV)(sv), class, &obj))
+	if h == 0 {
+		return 0, false
+	}
+	go2xsObjectsMu.Lock()
+	defer go2xsObjectsMu.Unlock()
+	if p, ok := go2xsObjects[h]; !ok || p != unsafe.Pointer(obj) {
+		return 0, false
+	}
+	return h, true
+}
+
+// go2xsObjectValue returns the Go value of the object.
+// It returns nil if sv is not an object of the class.
+func go2xsObjectValue(sv unsafe.Pointer, class string) interface{} {
+	h, ok := go2xsObjectHandle(sv, class)
+	if !ok {
+		return nil
+	}
+	return h.Value()
+}
+
+// go2xsDestroyObject releases the Go value of the object.
+// It does nothing if sv is not a live object.
+func go2xsDestroyObject(sv unsafe.Pointer) {
+	h, ok := go2xsObjectHandle(sv, "")
+	if !ok {
+		return
+	}
+	go2xsObjectsMu.Lock()
+	delete(go2xsObjects, h)
+	go2xsObjectsMu.Unlock()
+	if v, ok := h.Value().(interface{ go2xsRelease() }); ok {
+		v.go2xsRelease()
+	}
+	h.Delete()
+}
+
+// go2xsPathError is an error of converting SV, with the path to the broken value.
+type go2xsPathError struct {
+	path string
+	msg  string
+}
+
+func (e *go2xsPathError) Error() string {
+	return e.path + ": " + e.msg
+}
+
+// go2xsWrapPath prepends the path to the error.
+func go2xsWrapPath(err error, path string) error {
+	if e, ok := err.(*go2xsPathError); ok {
+		return &go2xsPathError{path: path + e.path, msg: e.msg}
+	}
+	return &go2xsPathError{path: path, msg: err.Error()}
+}
+
+// go2xsTypeError is the error for SV of unexpected type.
+func go2xsTypeError(expected string) error {
+	return &go2xsPathError{msg: "expected " + expected}
+}
+
+func go2xsIndexPath(i int) string {
+	return "[" + strconv.Itoa(i) + "]"
+}
+
+func go2xsKeyPath(key string) string {
+	return "{" + key + "}"
+}
+
+// go2xsNewError converts the error into SV for croaking.
+func go2xsNewError(err error) unsafe.Pointer {
+	return go2xsNewSVString(err.Error())
+}
+
+// go2xsPanicError converts the recovered value into SV for croaking.
+// The stack trace is appended if go2xsPanicStackTrace is true.
+func go2xsPanicError(name string, r interface{}) unsafe.Pointer {
+	var stack []byte
+	if p, ok := r.(*go2xsGoroutinePanic); ok {
+		r, stack = p.value, p.stack
+	}
+	if err, ok := r.(*go2xsPerlError); ok {
+		// rethrow the exception of Perl as is
+		return go2xsNewSVString(err.msg)
+	}
+	msg := fmt.Sprintf("%s: panic: %v", name, r)
+	if go2xsPanicStackTrace {
+		if stack == nil {
+			stack = debug.Stack()
+		}
+		msg += "\n\n" + string(stack)
+	}
+	return go2xsNewSVString(msg)
+}
+`
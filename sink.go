@@ -0,0 +1,90 @@
+package go2xs
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Sink is the destination of the generated files.
+type Sink interface {
+	// WriteFile writes the file. The name is slash-separated, e.g. "lib/Foo.pm".
+	WriteFile(name string, data []byte) error
+}
+
+// CleanSink is the Sink that can remove the stale files of the previous generation.
+type CleanSink interface {
+	Sink
+
+	// ReadFile reads the file. It returns an error that wraps fs.ErrNotExist if the file doesn't exist.
+	ReadFile(name string) ([]byte, error)
+
+	// Remove removes the file.
+	Remove(name string) error
+}
+
+// DirSink writes the files into the directory.
+// The parent directories of the files are created if needed.
+type DirSink string
+
+func (dir DirSink) path(name string) string {
+	return filepath.Join(string(dir), filepath.FromSlash(name))
+}
+
+// WriteFile writes the file atomically.
+// The data is written into a temporary file, and it is renamed to the name.
+func (dir DirSink) WriteFile(name string, data []byte) error {
+	path := dir.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (dir DirSink) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(dir.path(name))
+}
+
+func (dir DirSink) Remove(name string) error {
+	return os.Remove(dir.path(name))
+}
+
+// MapSink keeps the files in memory, keyed by their names.
+type MapSink map[string][]byte
+
+func (m MapSink) WriteFile(name string, data []byte) error {
+	m[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m MapSink) ReadFile(name string) ([]byte, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (m MapSink) Remove(name string) error {
+	if _, ok := m[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m, name)
+	return nil
+}
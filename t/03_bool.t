@@ -0,0 +1,21 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+//go2xs not
+func not(b bool) bool {
+  return !b
+}
+EOF
+
+ok !go2xstest::not(1), "1 is true";
+ok go2xstest::not(0), "0 is false";
+ok go2xstest::not(""), "empty string is false";
+ok !go2xstest::not("abc"), "non-empty string is true";
+ok go2xstest::not(undef), "undef is false";
+is go2xstest::not(1), "", "false is PL_sv_no";
+is go2xstest::not(0), 1, "true is PL_sv_yes";
+
+done_testing;
@@ -0,0 +1,32 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+//go2xs reverse
+func reverse(b []byte) []byte {
+  r := make([]byte, len(b))
+  for i, c := range b {
+    r[len(b)-i-1] = c
+  }
+  return r
+}
+
+//go2xs length noescape
+func length(b []byte) int {
+  return len(b)
+}
+EOF
+
+is go2xstest::reverse("abc"), "cba";
+is go2xstest::reverse("\x00\x01\xff"), "\xff\x01\x00", "binary data";
+is go2xstest::reverse(""), "", "empty";
+ok !utf8::is_utf8(go2xstest::reverse("abc")), "result is a byte string";
+
+is go2xstest::length("\x00\xff"), 2;
+is go2xstest::length("\xe3\x81\x82"), 3, "not decoded from UTF-8";
+eval { go2xstest::length("\x{3042}") };
+ok $@, "wide characters are rejected";
+
+done_testing;
@@ -0,0 +1,91 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+import "strings"
+
+//go2xs sum
+func sum(list []int) int {
+  s := 0
+  for _, v := range list {
+    s += v
+  }
+  return s
+}
+
+//go2xs double
+func double(list []float64) []float64 {
+  for i := range list {
+    list[i] *= 2
+  }
+  return list
+}
+
+//go2xs split
+func split(s string) []string {
+  return strings.Split(s, ",")
+}
+
+//go2xs join
+func join(list []string) string {
+  return strings.Join(list, ",")
+}
+
+//go2xs not
+func not(list []bool) []bool {
+  for i := range list {
+    list[i] = !list[i]
+  }
+  return list
+}
+
+//go2xs nilSlice
+func nilSlice() []int {
+  return nil
+}
+
+//go2xs trace
+func trace(m [][]int) int {
+  t := 0
+  for i := range m {
+    t += m[i][i]
+  }
+  return t
+}
+
+//go2xs matrix
+func matrix(n int) [][]int {
+  m := make([][]int, n)
+  for i := range m {
+    m[i] = make([]int, n)
+    m[i][i] = 1
+  }
+  return m
+}
+EOF
+
+is go2xstest::sum([1, 2, 3]), 6;
+is go2xstest::sum([]), 0, "empty array";
+is_deeply go2xstest::double([1.5, 2]), [3, 4];
+is_deeply go2xstest::split("a,b,c"), ["a", "b", "c"];
+is go2xstest::join(["a", "b", "c"]), "a,b,c";
+is_deeply [map { !!$_ } @{go2xstest::not([1, 0])}], [!!0, !!1];
+is go2xstest::nilSlice(), undef, "nil slice is undef";
+is_deeply go2xstest::matrix(2), [[1, 0], [0, 1]], "nested slices";
+
+my $list = [1, 2, 3];
+go2xstest::double($list);
+is_deeply $list, [1, 2, 3], "the original array is not changed";
+
+eval { go2xstest::sum(1) };
+like $@, qr/^list: expected an ARRAY reference/, "not an array reference";
+eval { go2xstest::sum(undef) };
+like $@, qr/^list: expected an ARRAY reference/, "undef";
+eval { go2xstest::sum({}) };
+like $@, qr/^list: expected an ARRAY reference/, "hash reference";
+eval { go2xstest::trace([[1], 2]) };
+like $@, qr/^m\[1\]: expected an ARRAY reference/, "nested array";
+
+done_testing;
@@ -0,0 +1,71 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+import "strings"
+
+//go2xs upper
+func upper(m map[string]string) map[string]string {
+  r := make(map[string]string, len(m))
+  for k, v := range m {
+    r[k] = strings.ToUpper(v)
+  }
+  return r
+}
+
+//go2xs count
+func count(m map[string]int) int {
+  s := 0
+  for _, v := range m {
+    s += v
+  }
+  return s
+}
+
+//go2xs nested
+func nested(m map[string]map[string]int) map[string]map[string]int {
+  return m
+}
+
+//go2xs list
+func list(l []map[string]string) []map[string]string {
+  return l
+}
+
+//go2xs groups
+func groups(m map[string][]string) map[string][]string {
+  return m
+}
+
+//go2xs nilMap
+func nilMap() map[string]int {
+  return nil
+}
+EOF
+
+is_deeply go2xstest::upper({ foo => "bar", hoge => "fuga" }), { foo => "BAR", hoge => "FUGA" };
+is_deeply go2xstest::upper({}), {}, "empty hash";
+is go2xstest::count({ a => 1, b => 2, c => 3 }), 6;
+is go2xstest::nilMap(), undef, "nil map is undef";
+
+my $nested = { a => { b => 1, c => 2 }, d => {} };
+is_deeply go2xstest::nested($nested), $nested, "nested maps";
+
+my $list = [{ a => "b" }, { c => "d" }];
+is_deeply go2xstest::list($list), $list, "slices of maps";
+
+my $groups = { a => ["b", "c"], d => [] };
+is_deeply go2xstest::groups($groups), $groups, "maps of slices";
+
+eval { go2xstest::count([]) };
+like $@, qr/^m: expected a HASH reference/, "not a hash reference";
+eval { go2xstest::count(undef) };
+like $@, qr/^m: expected a HASH reference/, "undef";
+eval { go2xstest::nested({ a => { b => 1 }, c => 1 }) };
+like $@, qr/^m\{c\}: expected a HASH reference/, "nested hash";
+eval { go2xstest::list([{}, []]) };
+like $@, qr/^l\[1\]: expected a HASH reference/, "slices of maps";
+
+done_testing;
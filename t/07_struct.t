@@ -0,0 +1,133 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+type Point struct {
+  X int
+  Y int
+}
+
+type User struct {
+  ID       int64    `go2xs:"id"`
+  Name     string   `json:"name"`
+  Email    string   `json:"email,omitempty"`
+  Tags     []string `go2xs:"tags,omitempty" json:"labels"`
+  Location *Point   `json:"location,omitempty"`
+  Password string   `json:"-"`
+  internal int
+}
+
+type Node struct {
+  Value    string  `json:"value"`
+  Children []*Node `json:"children,omitempty"`
+}
+
+type Embedded struct {
+  ID   int    `json:"id"`
+  Name string `json:"name"`
+}
+
+type Timestamps struct {
+  Created string `json:"created"`
+}
+
+type Item struct {
+  Embedded
+  *Timestamps
+  Name  string `json:"name"`
+  Title string `json:"title"`
+}
+
+type Conflict struct {
+  A
+  B
+}
+
+type A struct {
+  Dup int
+  OnlyA int
+}
+
+type B struct {
+  Dup int
+}
+
+//go2xs echoItem
+func echoItem(i Item) Item {
+  return i
+}
+
+//go2xs itemCreated
+func itemCreated(i Item) string {
+  if i.Timestamps == nil {
+    return "nil"
+  }
+  return i.Created
+}
+
+//go2xs echoConflict
+func echoConflict(c Conflict) Conflict {
+  return c
+}
+
+//go2xs move
+func move(p Point, dx, dy int) Point {
+  p.X += dx
+  p.Y += dy
+  return p
+}
+
+//go2xs echo
+func echo(u *User) *User {
+  return u
+}
+
+//go2xs newUser
+func newUser(name string) User {
+  return User{ID: 1, Name: name, Password: "secret"}
+}
+
+//go2xs count
+func count(n *Node) int {
+  if n == nil {
+    return 0
+  }
+  c := 1
+  for _, child := range n.Children {
+    c += count(child)
+  }
+  return c
+}
+EOF
+
+is_deeply go2xstest::move({ X => 1, Y => 2 }, 1, 1), { X => 2, Y => 3 };
+is_deeply go2xstest::move({ X => 1 }, 1, 1), { X => 2, Y => 1 }, "missing keys are zero";
+
+is_deeply go2xstest::newUser("foo"), { id => 1, name => "foo" }, "omitempty";
+my $user = {
+  id       => 2,
+  name     => "bar",
+  email    => 'bar@example.com',
+  tags     => ["a", "b"],
+  location => { X => 1, Y => 2 },
+};
+is_deeply go2xstest::echo($user), $user, "struct tags";
+is_deeply go2xstest::echo({ id => 3, name => "baz", Password => "pass", internal => 1 }), { id => 3, name => "baz" }, "ignored fields";
+is go2xstest::echo(undef), undef, "nil pointer";
+
+is go2xstest::count({ value => "a", children => [{ value => "b" }, { value => "c", children => [{ value => "d" }] }] }), 4, "recursive struct";
+
+is_deeply go2xstest::echoItem({ id => 3, name => "x", title => "t" }), { id => 3, name => "x", title => "t" }, "embedded struct";
+is_deeply go2xstest::echoItem({ id => 3, name => "x", title => "t", created => "now" }), { id => 3, name => "x", title => "t", created => "now" }, "embedded pointer";
+is go2xstest::itemCreated({ id => 3 }), "nil", "embedded pointer is not allocated";
+is go2xstest::itemCreated({ created => "now" }), "now", "embedded pointer is allocated";
+is_deeply go2xstest::echoConflict({ Dup => 1, OnlyA => 2 }), { OnlyA => 2 }, "ambiguous fields are ignored";
+
+eval { go2xstest::move([1, 2], 0, 0) };
+like $@, qr/^p: expected a HASH reference/;
+eval { go2xstest::echo({ location => [] }) };
+like $@, qr/^u\{location\}: expected a HASH reference/;
+
+done_testing;
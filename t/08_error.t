@@ -0,0 +1,46 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+import (
+  "errors"
+  "strconv"
+)
+
+//go2xs atoi
+func atoi(s string) (int, error) {
+  return strconv.Atoi(s)
+}
+
+//go2xs check
+func check(ok bool) error {
+  if !ok {
+    return errors.New("check failed")
+  }
+  return nil
+}
+
+//go2xs split
+func split(s string) ([]string, string, error) {
+  if s == "" {
+    return nil, "", errors.New("empty")
+  }
+  return []string{s[:1]}, s[1:], nil
+}
+EOF
+
+is go2xstest::atoi("123"), 123;
+eval { go2xstest::atoi("abc") };
+like $@, qr/^atoi: strconv.Atoi: parsing "abc": invalid syntax at /, "error message with the function name";
+
+is_deeply [go2xstest::check(1)], [], "no results";
+eval { go2xstest::check(0) };
+like $@, qr/^check: check failed at /;
+
+is_deeply [go2xstest::split("abc")], [["a"], "bc"], "multiple results";
+eval { go2xstest::split("") };
+like $@, qr/^split: empty at /;
+
+done_testing;
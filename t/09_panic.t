@@ -0,0 +1,27 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+//go2xs boom
+func boom(msg string) string {
+  panic(msg)
+}
+
+//go2xs index
+func index(list []int, i int) int {
+  return list[i]
+}
+EOF
+
+eval { go2xstest::boom("oops") };
+like $@, qr/^boom: panic: oops at /, "panic";
+
+is go2xstest::index([1, 2, 3], 1), 2;
+eval { go2xstest::index([1, 2, 3], 5) };
+like $@, qr/^index: panic: runtime error: index out of range/, "runtime error";
+
+is go2xstest::index([1, 2, 3], 2), 3, "the process is still alive";
+
+done_testing;
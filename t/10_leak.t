@@ -0,0 +1,46 @@
+use Test::More;
+use t::Util;
+
+plan skip_all => "RSS is read from /proc/self/statm" unless -r "/proc/self/statm";
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+import "strings"
+
+//go2xs repeat
+func repeat(s string, n int) string {
+  return strings.Repeat(s, n)
+}
+
+//go2xs bytes
+func bytes(n int) []byte {
+  return make([]byte, n)
+}
+EOF
+
+sub rss {
+    open my $fh, '<', '/proc/self/statm' or die $!;
+    my (undef, $rss) = split / /, <$fh>;
+    return $rss * 4096;
+}
+
+sub stress {
+    for (1..100_000) {
+        go2xstest::repeat("a", 1024);
+        go2xstest::bytes(1024);
+    }
+}
+
+# warm up the Go runtime and the Perl allocator
+stress();
+
+my $before = rss();
+stress() for 1..5;
+my $after = rss();
+
+# 500k iterations leaking 1KiB from each call would be about 1GiB.
+cmp_ok $after - $before, '<', 16 * 1024 * 1024, "RSS stays flat"
+    or diag "before: $before, after: $after";
+
+done_testing;
@@ -0,0 +1,34 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+//go2xs add
+func add(a, b int) int {
+  return a + b
+}
+
+//go2xs hello
+func hello() string {
+  return "Hello"
+}
+
+//go2xs unnamed
+func unnamed(int, string) {
+}
+EOF
+
+is go2xstest::add(1, 2), 3;
+is go2xstest::hello(), "Hello";
+
+eval { go2xstest::add(1) };
+like $@, qr/^Usage: go2xstest::add\(a, b\) at /, "too few arguments";
+eval { go2xstest::add(1, 2, 3) };
+like $@, qr/^Usage: go2xstest::add\(a, b\) at /, "too many arguments";
+eval { go2xstest::hello(1) };
+like $@, qr/^Usage: go2xstest::hello\(\) at /, "no arguments";
+eval { go2xstest::unnamed() };
+like $@, qr/^Usage: go2xstest::unnamed\(param0, param1\) at /, "unnamed parameters";
+
+done_testing;
@@ -0,0 +1,116 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+//go2xs int8 strict
+func int8_(a int8) int8 {
+  return a
+}
+
+//go2xs uint8 strict
+func uint8_(a uint8) uint8 {
+  return a
+}
+
+//go2xs int64 strict
+func int64_(a int64) int64 {
+  return a
+}
+
+//go2xs uint64 strict
+func uint64_(a uint64) uint64 {
+  return a
+}
+
+//go2xs float32 strict
+func float32_(a float32) float32 {
+  return a
+}
+
+//go2xs float64 strict
+func float64_(a float64) float64 {
+  return a
+}
+
+//go2xs sum strict
+func sum(list []int16) int16 {
+  var s int16
+  for _, v := range list {
+    s += v
+  }
+  return s
+}
+
+//go2xs wrap
+func wrap(a int8) int8 {
+  return a
+}
+EOF
+
+is go2xstest::int8(127), 127;
+is go2xstest::int8(-128), -128;
+is go2xstest::int8("12"), 12, "numeric string";
+eval { go2xstest::int8(128) };
+like $@, qr/^a: 128 is out of range for int8 at /;
+eval { go2xstest::int8(-129) };
+like $@, qr/^a: -129 is out of range for int8 at /;
+eval { go2xstest::int8("abc") };
+like $@, qr/^a: "abc" is not a number at /;
+eval { go2xstest::int8(undef) };
+like $@, qr/^a: undef is not a number at /;
+
+is go2xstest::uint8(255), 255;
+eval { go2xstest::uint8(256) };
+like $@, qr/^a: 256 is out of range for uint8 at /;
+eval { go2xstest::uint8(-1) };
+like $@, qr/^a: -1 is out of range for uint8 at /, "negative values for unsigned types";
+
+is go2xstest::int64(-9223372036854775808), -9223372036854775808;
+eval { go2xstest::int64(9223372036854775808) };
+like $@, qr/^a: \S+ is out of range for int64 at /;
+eval { go2xstest::int64(2**63) };
+like $@, qr/^a: \S+ is out of range for int64 at /, "2**63";
+eval { go2xstest::int64("9223372036854775808") };
+like $@, qr/^a: \S+ is out of range for int64 at /, "2**63 as a string";
+eval { go2xstest::int64(-2**63-1) };
+like $@, qr/^a: \S+ is out of range for int64 at /, "-2**63-1";
+eval { go2xstest::int64("-9223372036854775809") };
+like $@, qr/^a: \S+ is out of range for int64 at /, "-2**63-1 as a string";
+eval { go2xstest::int64(1e20) };
+like $@, qr/^a: \S+ is out of range for int64 at /;
+eval { go2xstest::int8("nan") };
+like $@, qr/^a: \S+ is out of range for int8 at /, "NaN";
+eval { go2xstest::int64(9**9**9 / 9**9**9) };
+like $@, qr/^a: \S+ is out of range for int64 at /, "NaN of numbers";
+eval { go2xstest::uint8("nan") };
+like $@, qr/^a: \S+ is out of range for uint8 at /, "NaN for unsigned types";
+
+is go2xstest::uint64(18446744073709551615), 18446744073709551615;
+is go2xstest::uint64("18446744073709551615"), 18446744073709551615, "2**64-1 as a string";
+eval { go2xstest::uint64(2**64) };
+like $@, qr/^a: \S+ is out of range for uint64 at /, "2**64";
+eval { go2xstest::uint64("18446744073709551616") };
+like $@, qr/^a: \S+ is out of range for uint64 at /, "2**64 as a string";
+eval { go2xstest::uint64(-1) };
+like $@, qr/^a: -1 is out of range for uint64 at /;
+
+is go2xstest::float32(1.5), 1.5;
+is go2xstest::float32(9**9**9), 9**9**9, "infinity";
+eval { go2xstest::float32(1e300) };
+like $@, qr/^a: \S+ is out of range for float32 at /;
+eval { go2xstest::float32(-1e300) };
+like $@, qr/^a: \S+ is out of range for float32 at /;
+is go2xstest::float64(1.5), 1.5;
+is go2xstest::float64(1e300), 1e300;
+eval { go2xstest::float64("1.5abc") };
+like $@, qr/^a: "1.5abc" is not a number at /;
+
+is go2xstest::sum([1, 2, 3]), 6;
+eval { go2xstest::sum([1, 40000]) };
+like $@, qr/^list\[1\]: 40000 is out of range for int16 at /, "elements of slices";
+
+is go2xstest::wrap(300), 44, "not strict";
+
+done_testing;
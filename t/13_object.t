@@ -0,0 +1,129 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+import (
+  "runtime"
+  "sync/atomic"
+  "time"
+)
+
+var live int64
+
+type Counter struct {
+  n int
+
+  // avoid the tiny allocator, finalizers of tiny objects may not run
+  _ [16]byte
+}
+
+//go2xs new
+func NewCounter(start int) *Counter {
+  atomic.AddInt64(&live, 1)
+  c := &Counter{n: start}
+  runtime.SetFinalizer(c, func(*Counter) { atomic.AddInt64(&live, -1) })
+  return c
+}
+
+//go2xs incr
+func (c *Counter) Incr(d int) int {
+  c.n += d
+  return c.n
+}
+
+//go2xs value
+func (c Counter) Value() int {
+  return c.n
+}
+
+//go2xs clone
+func (c *Counter) Clone() *Counter {
+  d := NewCounter(0)
+  d.n = c.n
+  return d
+}
+
+//go2xs sum
+func sum(a, b *Counter) int {
+  return a.n + b.n
+}
+
+// the glue code must not collide with the methods, and the fixed exports
+//go2xs Counter_incr
+func counterIncr(n int) int {
+  return n + 1
+}
+
+//go2xs Pump
+func pump() int {
+  return 42
+}
+
+//go2xs live
+func liveCounters() int {
+  for i := 0; i < 100 && atomic.LoadInt64(&live) > 0; i++ {
+    runtime.GC()
+    time.Sleep(10 * time.Millisecond)
+  }
+  return int(atomic.LoadInt64(&live))
+}
+EOF
+
+my $c = go2xstest::Counter->new(10);
+isa_ok $c, "go2xstest::Counter";
+is $c->incr(5), 15;
+is $c->value, 15, "value receiver";
+
+my $d = $c->clone;
+isa_ok $d, "go2xstest::Counter";
+$d->incr(1);
+is $c->value, 15;
+is $d->value, 16;
+is go2xstest::sum($c, $d), 31, "objects as parameters";
+
+is go2xstest::Counter_incr(1), 2, "function named like a method";
+is go2xstest::Pump(), 42, "function named like the fixed exports";
+
+eval { go2xstest::Counter::value({}) };
+like $@, qr/^self: expected a go2xstest::Counter object at /;
+eval { go2xstest::Counter::value(undef) };
+like $@, qr/^self: expected a go2xstest::Counter object at /;
+eval { go2xstest::sum($c, bless {}, "Foo") };
+like $@, qr/^b: expected a go2xstest::Counter object at /;
+eval { $c->incr };
+like $@, qr/^Usage: go2xstest::Counter::incr\(self, d\) at /;
+eval { $$c = 1 };
+ok $@, "the handle is read-only";
+
+{
+    # copies of the handle, e.g. by Storable::dclone, are not the live objects
+    my $copy = bless \(my $h = $$c), "go2xstest::Counter";
+    eval { $copy->value };
+    like $@, qr/^self: expected a go2xstest::Counter object at /, "copied handle";
+    my $forged = bless \(my $x = 999), "go2xstest::Counter";
+    eval { $forged->value };
+    like $@, qr/^self: expected a go2xstest::Counter object at /, "forged handle";
+}
+is $c->value, 15, "destroying the copies doesn't release the object";
+is(go2xstest::Counter->CLONE_SKIP, 1, "objects are not cloned by threads");
+
+{
+    package My::Counter;
+    our @ISA = ("go2xstest::Counter");
+    sub double { $_[0]->incr($_[0]->value) }
+}
+my $e = My::Counter->new(3);
+isa_ok $e, "My::Counter", "subclass";
+is $e->double, 6, "methods of the subclass";
+isa_ok $e->clone, "go2xstest::Counter", "objects created by Go";
+eval { go2xstest::Counter::new("Foo", 1) };
+like $@, qr/^CLASS: expected a subclass of go2xstest::Counter at /;
+undef $e;
+
+undef $c;
+undef $d;
+is go2xstest::live(), 0, "Go objects are released";
+
+done_testing;
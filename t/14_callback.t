@@ -0,0 +1,70 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+import "errors"
+
+//go2xs apply
+func apply(f func(int) int, v int) int {
+  return f(v)
+}
+
+//go2xs each
+func each(list []string, f func(int, string)) {
+  for i, s := range list {
+    f(i, s)
+  }
+}
+
+//go2xs try
+func try(f func() (string, error)) (string, error) {
+  s, err := f()
+  if err != nil {
+    return "", errors.New("failed: " + err.Error())
+  }
+  return s, nil
+}
+
+//go2xs call
+func call(f func()) {
+  f()
+}
+
+var saved func(string) string
+
+//go2xs save
+func save(f func(string) string) {
+  saved = f
+}
+
+//go2xs call_saved
+func callSaved(s string) string {
+  return saved(s)
+}
+EOF
+
+is go2xstest::apply(sub { $_[0] * 2 }, 21), 42, 'apply';
+
+my @got;
+go2xstest::each([qw(foo bar)], sub { push @got, "$_[0]:$_[1]" });
+is_deeply \@got, ['0:foo', '1:bar'], 'each';
+
+is go2xstest::try(sub { "ok" }), "ok", 'try';
+eval { go2xstest::try(sub { die "oops\n" }) };
+like $@, qr/^try: failed: oops at /, 'die is returned as an error';
+
+eval { go2xstest::call(sub { die "oops\n" }) };
+is $@, "oops\n", 'die is rethrown';
+
+eval { go2xstest::apply("foo", 1) };
+like $@, qr/expected a CODE reference/, 'not a code reference';
+
+{
+  my $prefix = "hello, ";
+  go2xstest::save(sub { $prefix . $_[0] });
+}
+is go2xstest::call_saved("world"), "hello, world", 'saved callback';
+
+done_testing;
@@ -0,0 +1,114 @@
+use Test::More;
+use t::Util;
+use IO::Select;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+import "sync"
+
+//go2xs parallel_map
+func parallelMap(list []int, f func(int) int) []int {
+  ret := make([]int, len(list))
+  var wg sync.WaitGroup
+  for i, v := range list {
+    wg.Add(1)
+    go func(i, v int) {
+      defer wg.Done()
+      ret[i] = f(v)
+    }(i, v)
+  }
+  wg.Wait()
+  return ret
+}
+
+//go2xs later
+func later(f func(string), s string) {
+  go f(s)
+}
+
+//go2xs in_goroutine
+func inGoroutine(f func() error) error {
+  errCh := make(chan error)
+  go func() {
+    errCh <- f()
+  }()
+  return <-errCh
+}
+
+//go2xs crash
+func crash(f func()) {
+  panic("crash")
+}
+
+type Emitter struct {
+  handlers []func(int) int
+}
+
+//go2xs new
+func NewEmitter() *Emitter {
+  return &Emitter{}
+}
+
+//go2xs on
+func (e *Emitter) On(f func(int) int) {
+  e.handlers = append(e.handlers, f)
+}
+
+//go2xs run
+func (e *Emitter) Run(v int) int {
+  ch := make(chan int)
+  go func() {
+    sum := 0
+    for _, f := range e.handlers {
+      sum += f(v)
+    }
+    ch <- sum
+  }()
+  return <-ch
+}
+
+//go2xs call_all
+func callAll(fs []func(int) int, v int) []int {
+  ret := make([]int, len(fs))
+  var wg sync.WaitGroup
+  for i, f := range fs {
+    wg.Add(1)
+    go func(i int, f func(int) int) {
+      defer wg.Done()
+      ret[i] = f(v)
+    }(i, f)
+  }
+  wg.Wait()
+  return ret
+}
+EOF
+
+is_deeply go2xstest::parallel_map([1..10], sub { $_[0] * 2 }), [map { $_ * 2 } 1..10], 'parallel_map';
+
+my @got;
+go2xstest::later(sub { push @got, $_[0] }, "foo");
+is_deeply \@got, [], 'not called until pumping';
+
+my $fd = go2xstest::go2xs_pump_fd();
+ok $fd >= 0, 'fd';
+open my $fh, '<&=', $fd or die $!;
+ok(IO::Select->new($fh)->can_read(10), 'readable');
+is go2xstest::go2xs_pump(), 1, 'pump';
+is_deeply \@got, ['foo'], 'called by pumping';
+is go2xstest::go2xs_pump(), 0, 'nothing to pump';
+
+eval { go2xstest::in_goroutine(sub { die "oops\n" }) };
+like $@, qr/^inGoroutine: oops at /, 'die in goroutine';
+
+eval { go2xstest::crash(sub {}) };
+like $@, qr/^crash: panic: crash/, 'panic';
+
+my $e = go2xstest::Emitter->new;
+$e->on(sub { $_[0] + 1 });
+$e->on(sub { $_[0] * 2 });
+is $e->run(10), 31, 'stored callbacks called from a goroutine';
+
+is_deeply go2xstest::call_all([sub { $_[0] + 1 }, sub { $_[0] * 2 }], 10), [11, 20], 'slice of callbacks called from goroutines';
+
+done_testing;
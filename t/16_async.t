@@ -0,0 +1,69 @@
+use Test::More;
+use t::Util;
+use IO::Select;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+import (
+  "errors"
+  "time"
+)
+
+//go2xs sleep_add async
+func sleepAdd(d int, a, b int) int {
+  time.Sleep(time.Duration(d) * time.Millisecond)
+  return a + b
+}
+
+//go2xs divmod async
+func divmod(a, b int) (int, int, error) {
+  if b == 0 {
+    return 0, 0, errors.New("division by zero")
+  }
+  return a / b, a % b, nil
+}
+
+//go2xs boom async
+func boom() {
+  panic("oops")
+}
+
+//go2xs progress async
+func progress(n int, f func(int)) string {
+  for i := 0; i < n; i++ {
+    f(i)
+  }
+  return "done"
+}
+EOF
+
+my $h = go2xstest::sleep_add(200, 1, 2);
+isa_ok $h, 'go2xstest::Async';
+ok !$h->ready, 'not ready';
+my $fh;
+open $fh, '<&=', $h->fd or die $!;
+ok(IO::Select->new($fh)->can_read(10), 'readable');
+ok $h->ready, 'ready';
+is $h->result, 3, 'result';
+is go2xstest::Async::result($h), 3, 'result again';
+
+is_deeply [go2xstest::divmod(7, 2)->result], [3, 1], 'multiple results';
+eval { go2xstest::divmod(1, 0)->result };
+like $@, qr/^divmod: division by zero at /, 'error';
+
+eval { go2xstest::boom()->result };
+like $@, qr/^boom: panic: oops/, 'panic';
+
+eval { go2xstest::Async::result("foo") };
+like $@, qr/^self: expected a go2xstest::Async object/, 'not a handle';
+eval { go2xstest::Async::result(bless \(my $x = 999), 'go2xstest::Async') };
+like $@, qr/^self: expected a go2xstest::Async object/, 'forged handle';
+is(go2xstest::Async->CLONE_SKIP, 1, 'handles are not cloned by threads');
+
+my @got;
+$h = go2xstest::progress(3, sub { push @got, $_[0] });
+is $h->result, "done", 'callbacks are called while waiting';
+is_deeply \@got, [0, 1, 2], 'callbacks';
+
+done_testing;
@@ -0,0 +1,96 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+import (
+  "strings"
+  "time"
+)
+
+type UserID int64
+
+type Name = string
+
+type Tags []string
+
+type Key string
+
+type User struct {
+  ID   UserID            `json:"id"`
+  Tags Tags              `json:"tags"`
+  Meta map[Key]string    `json:"meta"`
+}
+
+//go2xs next_id
+func nextID(id UserID) UserID {
+  return id + 1
+}
+
+//go2xs greet
+func greet(name Name) Name {
+  return "Hello, " + name
+}
+
+//go2xs first_byte
+func firstByte(s string) byte {
+  return s[0]
+}
+
+//go2xs first_rune
+func firstRune(s string) rune {
+  return []rune(s)[0]
+}
+
+//go2xs millis
+func millis(d time.Duration) int64 {
+  return d.Milliseconds()
+}
+
+//go2xs seconds
+func seconds(n int) time.Duration {
+  return time.Duration(n) * time.Second
+}
+
+//go2xs upper_tags
+func upperTags(tags Tags) Tags {
+  ret := make(Tags, len(tags))
+  for i, t := range tags {
+    ret[i] = strings.ToUpper(t)
+  }
+  return ret
+}
+
+//go2xs get_user
+func getUser(id UserID) User {
+  return User{ID: id, Tags: Tags{"a"}, Meta: map[Key]string{"k": "v"}}
+}
+
+//go2xs user_id
+func userID(u User) UserID {
+  return u.ID
+}
+
+//go2xs sum
+func sum(nums ...int) int {
+  s := 0
+  for _, n := range nums {
+    s += n
+  }
+  return s
+}
+EOF
+
+is go2xstest::next_id(41), 42, 'named integer';
+is go2xstest::greet("world"), "Hello, world", 'alias';
+is go2xstest::first_byte("abc"), 97, 'byte';
+is go2xstest::first_rune("\x{3042}"), 0x3042, 'rune';
+is go2xstest::millis(1500000000), 1500, 'imported type';
+is go2xstest::seconds(2), 2000000000, 'imported type result';
+is_deeply go2xstest::upper_tags(["foo", "bar"]), ["FOO", "BAR"], 'named slice';
+is_deeply go2xstest::get_user(1), { id => 1, tags => ["a"], meta => { k => "v" } }, 'named types in struct';
+is go2xstest::user_id({ id => 3 }), 3, 'struct from hash';
+is go2xstest::sum([1, 2, 3]), 6, 'variadic';
+
+done_testing;
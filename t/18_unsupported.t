@@ -0,0 +1,112 @@
+use Test::More;
+use t::Util;
+
+my ($exit, $stderr) = t::Util::generate("go2xstest", <<EOF);
+package main
+
+//go2xs send
+func send(ch chan int, v int) {
+  ch <- v
+}
+
+//go2xs complex
+func complexValue() complex128 {
+  return 0
+}
+
+//go2xs ok
+func ok(a int) int {
+  return a
+}
+EOF
+
+isnt $exit, 0, 'exit status';
+like $stderr, qr/test\.go:4:14: send: unsupported parameter type chan int/, 'parameter';
+like $stderr, qr/test\.go:9:21: complexValue: unsupported result type complex128/, 'result';
+
+($exit, $stderr) = t::Util::generate("go2xstest", <<EOF);
+package main
+
+//go2xs f
+func f(a undefinedType) int {
+  return 0
+}
+EOF
+
+isnt $exit, 0, 'exit status of type errors';
+like $stderr, qr/test\.go:4:10: undefined: undefinedType/, 'type error';
+
+($exit, $stderr) = t::Util::generate("go2xstest", <<EOF);
+package main
+
+type T struct{}
+
+//go2xs Foo::::bar
+func bar() {}
+
+//go2xs Foo::
+func baz() {}
+
+//go2xs Foo::method
+func (t *T) method() {}
+EOF
+
+isnt $exit, 0, 'exit status of invalid names';
+like $stderr, qr/test\.go:5:1: bar: invalid package name "Foo::"/, 'invalid package name';
+like $stderr, qr/test\.go:8:1: baz: invalid function name ""/, 'invalid function name';
+like $stderr, qr/test\.go:11:1: method: methods cannot be placed in other packages/, 'method';
+
+($exit, $stderr) = t::Util::generate("go2xstest", <<EOF);
+package main
+
+//go2xs f unknown context=array
+func f() {}
+
+//go2xs g strict=yes export=tag
+func g() {}
+
+//go2xs async
+func h() {}
+EOF
+
+isnt $exit, 0, 'exit status of invalid options';
+like $stderr, qr/test\.go:3:11: unknown go2xs option "unknown"/, 'unknown option';
+like $stderr, qr/test\.go:3:19: invalid value "array" of go2xs option context/, 'invalid context';
+like $stderr, qr/test\.go:6:11: invalid value "yes" of go2xs option strict/, 'invalid flag';
+like $stderr, qr/test\.go:6:22: invalid value "tag" of go2xs option export/, 'invalid export tag';
+like $stderr, qr/test\.go:9:9: ambiguous go2xs directive: use async=true for the option/, 'ambiguous flag';
+
+($exit, $stderr) = t::Util::generate("go2xstest", <<EOF);
+package main
+
+//go2xs add
+func add() {}
+
+//go2xs add
+func add2() {}
+
+//go2xs A::B::c_d
+func f1() {}
+
+//go2xs A::B_c::d
+func f2() {}
+EOF
+
+isnt $exit, 0, 'exit status of duplicated names';
+like $stderr, qr/test\.go:7:6: add2: Perl function add conflicts with add defined at \S*test\.go:4:6/, 'duplicated name';
+like $stderr, qr/test\.go:13:6: f2: Perl function A::B_c::d conflicts with A::B::c_d defined at \S*test\.go:10:6/, 'duplicated library name';
+
+($exit, $stderr) = t::Util::generate("go2xstest", <<EOF);
+package main
+
+//go2xs go2xstest::sub
+func sub() {}
+
+//go2xs sub
+func sub2() {}
+EOF
+
+isnt $exit, 0, 'exit status of duplicated names in the module';
+like $stderr, qr/test\.go:7:6: sub2: Perl function go2xstest::sub conflicts with go2xstest::sub defined at \S*test\.go:4:6/, 'qualified by the module name';
+
+done_testing;
@@ -0,0 +1,41 @@
+use Test::More;
+use t::Util;
+
+my ($exit, $stderr, $dir) = t::Util::generate("go2xstest", <<EOF, "-dir", "out");
+package main
+
+//go2xs add
+func add(a, b int) int {
+  return a + b
+}
+EOF
+
+is $exit, 0, 'exit status';
+for my $file (qw(ppport.h Makefile.PL go2xs.go go2xs_perl.go go2xstest.xs lib/go2xstest.pm)) {
+    ok -f "$dir/out/$file", "$file is written into the directory";
+    ok !-e "$dir/$file", "$file is not written into the working directory";
+}
+ok -f "$dir/out/test.go", 'Go files are copied into the directory';
+
+# regenerate with another name
+{
+    my $guard = Cwd::Guard::cwd_guard($dir);
+    system(t::Util::xs2go(), "-name", "go2xsother", "-dir", "out", "test.go") == 0 or die;
+}
+ok -f "$dir/out/go2xsother.xs", 'new file';
+ok !-e "$dir/out/go2xstest.xs", 'stale file is removed';
+ok !-e "$dir/out/lib/go2xstest.pm", 'stale module is removed';
+open my $fh, '<', "$dir/out/go2xs.manifest" or die $!;
+my @files = map { chomp; $_ } <$fh>;
+is_deeply \@files, [sort qw(ppport.h Makefile.PL go2xs.go go2xs_perl.go go2xsother.xs lib/go2xsother.pm test.go)], 'manifest';
+
+# build and load the module in the directory
+{
+    my $guard = Cwd::Guard::cwd_guard("$dir/out");
+    system("perl Makefile.PL") == 0 or die;
+    system("make") == 0 or die;
+}
+eval "use blib '$dir/out'; use go2xsother; 1" or die $@;
+is go2xsother::add(1, 2), 3, 'the module is built in the directory';
+
+done_testing;
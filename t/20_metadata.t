@@ -0,0 +1,66 @@
+use Test::More;
+use t::Util;
+use File::Temp qw/tempfile/;
+
+sub slurp {
+    my $file = shift;
+    open my $fh, '<', $file or die "$file: $!";
+    local $/;
+    return <$fh>;
+}
+
+my $gocode = <<EOF;
+package main
+
+//go2xs add
+func add(a, b int) int {
+  return a + b
+}
+EOF
+
+subtest 'flags' => sub {
+    my ($exit, $stderr, $dir) = t::Util::generate("go2xstest", $gocode,
+        "-module-version", "1.23", "-abstract", "Add numbers", "-author", "John Doe <john\@example.com>",
+        "-license", "mit", "-min-perl-version", "5.012000");
+    is $exit, 0, 'exit status';
+
+    my $makefile = slurp("$dir/Makefile.PL");
+    like $makefile, qr/^use 5\.012000;/, 'use perl version';
+    like $makefile, qr/ABSTRACT\s*=> 'Add numbers'/, 'abstract';
+    like $makefile, qr/AUTHOR\s*=> \['John Doe <john\@example\.com>'\]/, 'author';
+    like $makefile, qr/LICENSE\s*=> 'mit'/, 'license';
+    like $makefile, qr/MIN_PERL_VERSION\s*=> '5\.012000'/, 'min perl version';
+    unlike $makefile, qr/Ichinose Shogo/, 'no hard-coded author';
+
+    my $module = slurp("$dir/lib/go2xstest.pm");
+    like $module, qr/^package go2xstest;/, 'package name';
+    like $module, qr/^our \$VERSION = '1\.23';/m, 'version';
+    like $module, qr/^go2xstest - Add numbers$/m, 'abstract in POD';
+    like $module, qr/^John Doe E<lt>john\@example\.comE<gt>$/m, 'author in POD';
+    unlike $module, qr/SomeModule|blah/, 'no placeholders';
+};
+
+subtest 'config file' => sub {
+    my ($fh, $config) = tempfile(SUFFIX => '.json', UNLINK => 1);
+    print $fh '{"version": "2.0", "license": "perl_5", "author": "Jane Doe"}';
+    close $fh;
+
+    # flags override the config file
+    my ($exit, $stderr, $dir) = t::Util::generate("go2xstest", $gocode, "-config", $config, "-author", "John Doe");
+    is $exit, 0, 'exit status';
+
+    my $makefile = slurp("$dir/Makefile.PL");
+    like $makefile, qr/LICENSE\s*=> 'perl_5'/, 'license';
+    like $makefile, qr/AUTHOR\s*=> \['John Doe'\]/, 'author';
+
+    my $module = slurp("$dir/lib/go2xstest.pm");
+    like $module, qr/^our \$VERSION = '2\.0';/m, 'version';
+};
+
+subtest 'invalid version' => sub {
+    my ($exit, $stderr, $dir) = t::Util::generate("go2xstest", $gocode, "-module-version", "1.0; system('rm')");
+    isnt $exit, 0, 'exit status';
+    like $stderr, qr/invalid version/, 'message';
+};
+
+done_testing;
@@ -0,0 +1,36 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("Acme::Go2xsTest", <<EOF);
+package main
+
+type Counter struct {
+  n int
+}
+
+//go2xs new
+func NewCounter(start int) *Counter {
+  return &Counter{n: start}
+}
+
+//go2xs incr
+func (c *Counter) Incr(d int) int {
+  c.n += d
+  return c.n
+}
+
+//go2xs add
+func add(a, b int) int {
+  return a + b
+}
+EOF
+
+is Acme::Go2xsTest::add(1, 2), 3, 'function';
+
+my $c = Acme::Go2xsTest::Counter->new(10);
+isa_ok $c, 'Acme::Go2xsTest::Counter';
+is $c->incr(5), 15, 'method';
+
+ok $INC{'Acme/Go2xsTest.pm'}, 'module file';
+
+done_testing;
@@ -0,0 +1,42 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+import "strings"
+
+//go2xs add
+func add(a, b int) int {
+  return a + b
+}
+
+//go2xs go2xstest::Util::upper
+func upper(s string) string {
+  return strings.ToUpper(s)
+}
+
+//go2xs go2xstest::Util::add
+func addFloat(a, b float64) float64 {
+  return a + b
+}
+
+//go2xs Go2xsOther::lower
+func lower(s string) string {
+  return strings.ToLower(s)
+}
+
+//go2xs go2xstest::sub
+func sub(a, b int) int {
+  return a - b
+}
+EOF
+
+is go2xstest::add(1, 2), 3, 'function of the module';
+is go2xstest::sub(3, 2), 1, 'qualified by the module name';
+is go2xstest::Util::upper("abc"), "ABC", 'function of a sub package';
+is go2xstest::Util::add(0.5, 0.25), 0.75, 'same name in other package';
+is Go2xsOther::lower("ABC"), "abc", 'function of another package';
+ok !defined &go2xstest::upper, 'not in the module';
+
+done_testing;
@@ -0,0 +1,43 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+//go2xs divmod context=list
+func divmod(a, b int) (int, int) {
+  return a / b, a % b
+}
+
+//go2xs divmod_ref context=scalar
+func divmodRef(a, b int) (int, int) {
+  return a / b, a % b
+}
+
+//go2xs add proto=\$\$
+func add(a, b int) int {
+  return a + b
+}
+
+//go2xs small strict=true
+func small(a int8) int8 {
+  return a
+}
+
+//go2xs loose strict=false
+func loose(a int8) int8 {
+  return a
+}
+EOF
+
+is_deeply [go2xstest::divmod(7, 3)], [2, 1], 'context=list';
+is_deeply scalar(go2xstest::divmod_ref(7, 3)), [2, 1], 'context=scalar';
+is_deeply [go2xstest::divmod_ref(7, 3)], [[2, 1]], 'context=scalar in list context';
+is prototype(\&go2xstest::add), '$$', 'proto';
+is prototype(\&go2xstest::divmod), undef, 'no proto';
+eval { go2xstest::small(1000) };
+ok $@, 'strict=true';
+eval { go2xstest::loose(1000) };
+ok !$@, 'strict=false';
+
+done_testing;
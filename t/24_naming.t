@@ -0,0 +1,52 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF, "-naming", "snake_case");
+package main
+
+type Counter struct {
+  n int
+}
+
+//go2xs new
+func NewCounter() *Counter {
+  return &Counter{}
+}
+
+//go2xs
+func (c *Counter) IncrBy(d int) int {
+  c.n += d
+  return c.n
+}
+
+//go2xs
+func ParseHTTPHeader(s string) string {
+  return s
+}
+
+//go2xs
+func add(a, b int) int {
+  return a + b
+}
+
+//go2xs strict=true
+func Int8Value(a int8) int8 {
+  return a
+}
+
+//go2xs AsIs
+func AsIs() int {
+  return 1
+}
+EOF
+
+is go2xstest::add(1, 2), 3, 'lower case';
+is go2xstest::parse_http_header("foo"), "foo", 'acronym';
+is go2xstest::int8_value(1), 1, 'digits';
+eval { go2xstest::int8_value(1000) };
+ok $@, 'options without the name';
+is go2xstest::AsIs(), 1, 'named by the directive';
+my $c = go2xstest::Counter->new;
+is $c->incr_by(2), 2, 'method';
+
+done_testing;
@@ -0,0 +1,34 @@
+use Test::More;
+use t::Util;
+
+t::Util::compile("go2xstest", <<EOF);
+package main
+
+//go2xs add
+func add(a, b int) int {
+  return a + b
+}
+
+//go2xs mul export=:math,:extra
+func mul(a, b int) int {
+  return a * b
+}
+
+//go2xs go2xstest::Util::upper
+func upper(s string) string {
+  return s
+}
+EOF
+
+is_deeply [sort @go2xstest::EXPORT_OK], [qw(add mul)], '@EXPORT_OK';
+is_deeply [sort @{$go2xstest::EXPORT_TAGS{all}}], [qw(add mul)], ':all';
+is_deeply $go2xstest::EXPORT_TAGS{test}, [qw(add)], 'tag of the file name';
+is_deeply $go2xstest::EXPORT_TAGS{math}, [qw(mul)], 'tag of the directive';
+is_deeply $go2xstest::EXPORT_TAGS{extra}, [qw(mul)], 'multiple tags';
+
+ok !defined &main::add, 'nothing is exported by default';
+go2xstest->import(qw(:all));
+is add(1, 2), 3, 'import :all';
+is mul(2, 3), 6, 'import :all';
+
+done_testing;
@@ -3,11 +3,22 @@ use File::Temp qw/tempdir/;
 use Cwd::Guard qw/cwd_guard/;
 use File::Basename;
 use File::Spec;
+use Capture::Tiny ();
 
-our $xs2go = File::Spec->rel2abs(File::Spec->catfile(dirname(dirname(__FILE__)), qw/cli go2xs main.go/));
+our $root = dirname(dirname(File::Spec->rel2abs(__FILE__)));
+
+# build go2xs in the module of the repository, so that it resolves the dependencies by go.mod
+my $xs2go;
+sub xs2go {
+    return $xs2go if $xs2go;
+    my $dir = tempdir( CLEANUP => 1 );
+    my $bin = File::Spec->catfile($dir, "go2xs");
+    system("go", "build", "-C", $root, "-o", $bin, "./cli/go2xs") == 0 or die "failed to build go2xs";
+    return $xs2go = $bin;
+}
 
 sub compile {
-    my ($name, $gocode) = @_;
+    my ($name, $gocode, @flags) = @_;
     my $dir = tempdir;#( CLEANUP => 1 );
     warn $dir;
 
@@ -17,11 +28,27 @@ sub compile {
     print $fh $gocode;
     close $fh;
 
-    system("go run $xs2go -name $name test.go") == 0 or die;
+    system(xs2go(), "-name", $name, @flags, "test.go") == 0 or die;
     system("perl Makefile.PL") == 0 or die;
     system("make") == 0 or die;
 
     eval "use blib '$dir'; use $name;";
 }
 
+# run go2xs only, and return the exit status, the error output and the working directory
+sub generate {
+    my ($name, $gocode, @flags) = @_;
+    my $dir = tempdir( CLEANUP => 1 );
+    my $guard = cwd_guard($dir);
+
+    open my $fh, '>', "test.go";
+    print $fh $gocode;
+    close $fh;
+
+    my ($stdout, $stderr, $exit) = Capture::Tiny::capture {
+        system(xs2go(), "-name", $name, @flags, "test.go");
+    };
+    return ($exit, $stderr, $dir);
+}
+
 1;